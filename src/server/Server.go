@@ -27,7 +27,8 @@ func WaitServer() string {
 	return ""
 }
 
+// Stop saves cache snapshot and closes torrents before db is closed and process exits
 func Stop() {
-	go server.Stop()
+	server.Stop()
 	settings.CloseDB()
 }
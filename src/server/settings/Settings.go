@@ -16,18 +16,53 @@ var (
 func init() {
 	sets = new(Settings)
 	sets.CacheSize = 200 * 1024 * 1024
+	sets.DiskCacheSize = 4 * 1024 * 1024 * 1024
+	sets.CachePolicy = "lru"
 	sets.PreloadBufferSize = 20 * 1024 * 1024
 	sets.ConnectionsLimit = 100
 	sets.RetrackersMode = 1
+	sets.StreamBufferTime = 30
+	sets.MetadataTimeout = 120
+	sets.Expire = ExpirePolicy{
+		InfoTimeout:    300,
+		IdleTimeout:    300,
+		PreloadTimeout: 60,
+		PinnedTimeout:  0,
+		MaxTorrents:    0,
+	}
 	sets.DisableDHT = true
+	sets.PeerProfile = "utorrent"
 	StartTime = time.Now()
 }
 
+type ExpirePolicy struct {
+	InfoTimeout    int // in seconds, keep torrent without readers after got info
+	IdleTimeout    int // in seconds, keep torrent after last reader closed
+	PreloadTimeout int // in seconds, keep torrent after preload
+	PinnedTimeout  int // in seconds, keep pinned torrent after last access, 0 - forever
+	MaxTorrents    int // open torrents limit, least recently used are dropped, 0 - inf
+}
+
 type Settings struct {
 	CacheSize         int64 // in byte, def 200 mb
 	PreloadBufferSize int64 // in byte, buffer for preload
 
-	RetrackersMode int //0 - don`t add, 1 - add retrackers, 2 - remove retrackers
+	CacheType     int    // 0 - memory, 1 - disk, 2 - memory with disk tier
+	DiskCacheSize int64  // in byte, def 4 gb
+	CachePolicy   string // memory cache eviction: lru, playhead, arc
+	CacheSnapshot bool   // save memory cache of watched torrents on stop and load it on start
+	VerifyPieces  bool   // rehash cached pieces in background, corrupted pieces are downloaded again
+
+	RetrackersMode int      //0 - don`t add, 1 - add retrackers, 2 - remove retrackers
+	Retrackers     []string // retrackers list, empty - default list
+
+	RestoreTorrents int // count of last used torrents to add on start, 0 - don`t restore
+
+	MetadataTimeout int // in seconds, torrent without info fails after it, 0 - wait forever
+
+	StreamBufferTime int // in seconds, buffer ahead of playing streams before other torrents get bandwidth, 0 - disable
+
+	Expire ExpirePolicy
 
 	//BT Config
 	DisableTCP        bool
@@ -35,10 +70,19 @@ type Settings struct {
 	DisableUPNP       bool
 	DisableDHT        bool
 	DisableUpload     bool
-	Encryption        int // 0 - Enable, 1 - disable, 2 - force
-	DownloadRateLimit int // in kb, 0 - inf
-	UploadRateLimit   int // in kb, 0 - inf
+	EnableIPv6        bool
+	EnableLSD         bool // local service discovery, BEP 14
+	Encryption        int  // 0 - Enable, 1 - disable, 2 - force
+	DownloadRateLimit int  // in kb, 0 - inf
+	UploadRateLimit   int  // in kb, 0 - inf
 	ConnectionsLimit  int
+	PeersListenPort   int    // 0 - random
+	BlocklistSource   string // file path or url of P2P, DAT or eMule blocklist
+
+	PeerProfile   string // utorrent, qbittorrent, transmission, deluge, torrserver, custom
+	PeerIDPrefix  string // custom profile peer id prefix, like -UT3490-
+	PeerBep20     string // custom profile bep20 string
+	PeerUserAgent string // custom profile http user agent
 }
 
 func Get() *Settings {
@@ -78,6 +122,9 @@ func ReadSettings() error {
 	if sets.CacheSize <= 0 {
 		sets.CacheSize = 200 * 1024 * 1024
 	}
+	if sets.DiskCacheSize <= 0 {
+		sets.DiskCacheSize = 4 * 1024 * 1024 * 1024
+	}
 	return nil
 }
 
@@ -2,7 +2,9 @@ package settings
 
 import (
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/boltdb/bolt"
 )
@@ -13,10 +15,26 @@ type Torrent struct {
 	Hash      string
 	Size      int64
 	Timestamp int64
+	Accessed  int64
+	Metainfo  []byte `json:"-"`
+
+	DownloadRateLimit int // in kb, 0 - inf
+	UploadRateLimit   int // in kb, 0 - inf
+	ConnectionsLimit  int // 0 - from settings
+
+	Pinned bool
+
+	Trackers [][]string // nil - trackers from metainfo and settings
+	WebSeeds []WebSeed
 
 	Files []File
 }
 
+type WebSeed struct {
+	Url      string
+	HttpSeed bool // BEP 17 http seed, else BEP 19 web seed
+}
+
 type File struct {
 	Name   string
 	Size   int64
@@ -39,6 +57,11 @@ func SetViewed(hash, filename string) error {
 			return fmt.Errorf("could not find torrent")
 		}
 
+		err = hdb.Put([]byte("Accessed"), i2b(time.Now().Unix()))
+		if err != nil {
+			return fmt.Errorf("error save torrent %v", err)
+		}
+
 		fdb := hdb.Bucket([]byte("Files"))
 		if fdb == nil {
 			return fmt.Errorf("could not find torrent")
@@ -57,6 +80,151 @@ func SetViewed(hash, filename string) error {
 	})
 }
 
+func SetMetainfo(hash string, buf []byte) error {
+	err := openDB()
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		dbt := tx.Bucket(dbTorrentsName)
+		if dbt == nil {
+			return fmt.Errorf("could not find torrent")
+		}
+		hdb := dbt.Bucket([]byte(hash))
+		if hdb == nil {
+			return fmt.Errorf("could not find torrent")
+		}
+		if hdb.Get([]byte("Metainfo")) != nil {
+			return nil
+		}
+
+		err = hdb.Put([]byte("Metainfo"), buf)
+		if err != nil {
+			return fmt.Errorf("error save torrent %v", err)
+		}
+		return nil
+	})
+}
+
+func SetTorrentLimits(hash string, dl, ul, conns int) error {
+	err := openDB()
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		dbt := tx.Bucket(dbTorrentsName)
+		if dbt == nil {
+			return fmt.Errorf("could not find torrent")
+		}
+		hdb := dbt.Bucket([]byte(hash))
+		if hdb == nil {
+			return fmt.Errorf("could not find torrent")
+		}
+
+		err = hdb.Put([]byte("DownloadRateLimit"), i2b(int64(dl)))
+		if err != nil {
+			return fmt.Errorf("error save torrent %v", err)
+		}
+		err = hdb.Put([]byte("UploadRateLimit"), i2b(int64(ul)))
+		if err != nil {
+			return fmt.Errorf("error save torrent %v", err)
+		}
+		err = hdb.Put([]byte("ConnectionsLimit"), i2b(int64(conns)))
+		if err != nil {
+			return fmt.Errorf("error save torrent %v", err)
+		}
+		return nil
+	})
+}
+
+func SetPinned(hash string, pinned bool) error {
+	err := openDB()
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		dbt := tx.Bucket(dbTorrentsName)
+		if dbt == nil {
+			return fmt.Errorf("could not find torrent")
+		}
+		hdb := dbt.Bucket([]byte(hash))
+		if hdb == nil {
+			return fmt.Errorf("could not find torrent")
+		}
+
+		b := 0
+		if pinned {
+			b = 1
+		}
+		err = hdb.Put([]byte("Pinned"), []byte{byte(b)})
+		if err != nil {
+			return fmt.Errorf("error save torrent %v", err)
+		}
+		return nil
+	})
+}
+
+func SetTrackers(hash string, trackers [][]string) error {
+	err := openDB()
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(trackers)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		dbt := tx.Bucket(dbTorrentsName)
+		if dbt == nil {
+			return fmt.Errorf("could not find torrent")
+		}
+		hdb := dbt.Bucket([]byte(hash))
+		if hdb == nil {
+			return fmt.Errorf("could not find torrent")
+		}
+
+		err = hdb.Put([]byte("Trackers"), buf)
+		if err != nil {
+			return fmt.Errorf("error save torrent %v", err)
+		}
+		return nil
+	})
+}
+
+func SetWebSeeds(hash string, seeds []WebSeed) error {
+	err := openDB()
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(seeds)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		dbt := tx.Bucket(dbTorrentsName)
+		if dbt == nil {
+			return fmt.Errorf("could not find torrent")
+		}
+		hdb := dbt.Bucket([]byte(hash))
+		if hdb == nil {
+			return fmt.Errorf("could not find torrent")
+		}
+
+		err = hdb.Put([]byte("WebSeeds"), buf)
+		if err != nil {
+			return fmt.Errorf("error save torrent %v", err)
+		}
+		return nil
+	})
+}
+
 func SaveTorrentDB(torrent *Torrent) error {
 	err := openDB()
 	if err != nil {
@@ -90,6 +258,32 @@ func SaveTorrentDB(torrent *Torrent) error {
 		if err != nil {
 			return fmt.Errorf("error save torrent: %v", err)
 		}
+		if len(torrent.Metainfo) > 0 {
+			err = hdb.Put([]byte("Metainfo"), torrent.Metainfo)
+			if err != nil {
+				return fmt.Errorf("error save torrent: %v", err)
+			}
+		}
+		if torrent.Trackers != nil {
+			buf, err := json.Marshal(torrent.Trackers)
+			if err != nil {
+				return fmt.Errorf("error save torrent: %v", err)
+			}
+			err = hdb.Put([]byte("Trackers"), buf)
+			if err != nil {
+				return fmt.Errorf("error save torrent: %v", err)
+			}
+		}
+		if len(torrent.WebSeeds) > 0 {
+			buf, err := json.Marshal(torrent.WebSeeds)
+			if err != nil {
+				return fmt.Errorf("error save torrent: %v", err)
+			}
+			err = hdb.Put([]byte("WebSeeds"), buf)
+			if err != nil {
+				return fmt.Errorf("error save torrent: %v", err)
+			}
+		}
 
 		fdb, err := hdb.CreateBucketIfNotExists([]byte("Files"))
 		if err != nil {
@@ -177,6 +371,39 @@ func LoadTorrentDB(hash string) (*Torrent, error) {
 			}
 			torr.Timestamp = b2i(tmp)
 
+			tmp = hdb.Get([]byte("Accessed"))
+			if tmp != nil {
+				torr.Accessed = b2i(tmp)
+			}
+
+			tmp = hdb.Get([]byte("Metainfo"))
+			if tmp != nil {
+				torr.Metainfo = append([]byte(nil), tmp...)
+			}
+
+			tmp = hdb.Get([]byte("DownloadRateLimit"))
+			if tmp != nil {
+				torr.DownloadRateLimit = int(b2i(tmp))
+			}
+			tmp = hdb.Get([]byte("UploadRateLimit"))
+			if tmp != nil {
+				torr.UploadRateLimit = int(b2i(tmp))
+			}
+			tmp = hdb.Get([]byte("ConnectionsLimit"))
+			if tmp != nil {
+				torr.ConnectionsLimit = int(b2i(tmp))
+			}
+			tmp = hdb.Get([]byte("Pinned"))
+			torr.Pinned = len(tmp) > 0 && tmp[0] == 1
+			tmp = hdb.Get([]byte("Trackers"))
+			if tmp != nil {
+				json.Unmarshal(tmp, &torr.Trackers)
+			}
+			tmp = hdb.Get([]byte("WebSeeds"))
+			if tmp != nil {
+				json.Unmarshal(tmp, &torr.WebSeeds)
+			}
+
 			fdb := hdb.Bucket([]byte("Files"))
 			if fdb == nil {
 				return fmt.Errorf("error load torrent files")
@@ -248,6 +475,39 @@ func LoadTorrentsDB() ([]*Torrent, error) {
 				}
 				torr.Timestamp = b2i(tmp)
 
+				tmp = hdb.Get([]byte("Accessed"))
+				if tmp != nil {
+					torr.Accessed = b2i(tmp)
+				}
+
+				tmp = hdb.Get([]byte("Metainfo"))
+				if tmp != nil {
+					torr.Metainfo = append([]byte(nil), tmp...)
+				}
+
+				tmp = hdb.Get([]byte("DownloadRateLimit"))
+				if tmp != nil {
+					torr.DownloadRateLimit = int(b2i(tmp))
+				}
+				tmp = hdb.Get([]byte("UploadRateLimit"))
+				if tmp != nil {
+					torr.UploadRateLimit = int(b2i(tmp))
+				}
+				tmp = hdb.Get([]byte("ConnectionsLimit"))
+				if tmp != nil {
+					torr.ConnectionsLimit = int(b2i(tmp))
+				}
+				tmp = hdb.Get([]byte("Pinned"))
+				torr.Pinned = len(tmp) > 0 && tmp[0] == 1
+				tmp = hdb.Get([]byte("Trackers"))
+				if tmp != nil {
+					json.Unmarshal(tmp, &torr.Trackers)
+				}
+				tmp = hdb.Get([]byte("WebSeeds"))
+				if tmp != nil {
+					json.Unmarshal(tmp, &torr.WebSeeds)
+				}
+
 				fdb := hdb.Bucket([]byte("Files"))
 				if fdb == nil {
 					return fmt.Errorf("error load torrent files")
@@ -0,0 +1,128 @@
+package torr
+
+import (
+	"fmt"
+
+	"server/settings"
+	"server/utils"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// needReconnect reports whether changed settings can be applied only to a new client
+func needReconnect(old, sets *settings.Settings) bool {
+	return old.DisableTCP != sets.DisableTCP ||
+		old.DisableUTP != sets.DisableUTP ||
+		old.DisableUPNP != sets.DisableUPNP ||
+		old.DisableDHT != sets.DisableDHT ||
+		old.EnableIPv6 != sets.EnableIPv6 ||
+		old.PeersListenPort != sets.PeersListenPort ||
+		GetPeerProfile(old) != GetPeerProfile(sets) ||
+		old.CacheType != sets.CacheType ||
+		old.CacheSize != sets.CacheSize ||
+		old.CachePolicy != sets.CachePolicy ||
+		old.CacheSnapshot != sets.CacheSnapshot ||
+		old.DiskCacheSize != sets.DiskCacheSize
+}
+
+// ApplySettings applies settings changed from old to running client
+func (bt *BTServer) ApplySettings(old *settings.Settings) error {
+	sets := settings.Get()
+	if needReconnect(old, sets) {
+		fmt.Println("Settings need restart torrent engine")
+		return bt.Restart()
+	}
+
+	bt.mu.Lock()
+	if bt.config != nil {
+		utils.SetLimit(bt.config.DownloadRateLimiter, sets.DownloadRateLimit*1024)
+		utils.SetLimit(bt.config.UploadRateLimiter, sets.UploadRateLimit*1024)
+		bt.config.EstablishedConnsPerTorrent = sets.ConnectionsLimit
+		bt.config.NoUpload = sets.DisableUpload
+		bt.config.EncryptionPolicy = torrent.EncryptionPolicy{
+			DisableEncryption: sets.Encryption == 1,
+			ForceEncryption:   sets.Encryption == 2,
+		}
+	}
+	if old.VerifyPieces != sets.VerifyPieces && bt.verifier != nil {
+		if sets.VerifyPieces {
+			bt.verifier.StartVerify(bt.recheckPiece)
+		} else {
+			bt.verifier.StopVerify()
+		}
+	}
+	if old.EnableLSD != sets.EnableLSD {
+		if sets.EnableLSD {
+			bt.startLSD()
+		} else {
+			bt.stopLSD()
+		}
+	}
+	bt.mu.Unlock()
+
+	for _, t := range bt.List() {
+		if old.ConnectionsLimit != sets.ConnectionsLimit && t.Limits().ConnectionsLimit <= 0 {
+			t.applyLimits()
+		}
+		if old.RetrackersMode != sets.RetrackersMode || !equalStrings(old.Retrackers, sets.Retrackers) {
+			t.applyRetrackers(old.RetrackersMode, utils.GetRetrackers(old))
+		}
+	}
+	return nil
+}
+
+// Restart reconnects client and adds back torrents, that were open
+func (bt *BTServer) Restart() error {
+	specs := make([]*torrent.TorrentSpec, 0)
+	seeds := make(map[metainfo.Hash][]settings.WebSeed)
+	for _, t := range bt.List() {
+		seeds[t.hash] = t.WebSeeds()
+		t.muTorrent.Lock()
+		if t.Torrent != nil {
+			if t.Torrent.Info() != nil {
+				mi := t.Metainfo()
+				specs = append(specs, torrent.TorrentSpecFromMetaInfo(&mi))
+			} else {
+				specs = append(specs, &torrent.TorrentSpec{
+					InfoHash:    t.hash,
+					DisplayName: t.Torrent.Name(),
+					Trackers:    t.ownAnnounceList(),
+				})
+			}
+		}
+		t.muTorrent.Unlock()
+	}
+
+	//Old torrents are closed before client, so they don't remove torrents added again
+	for _, t := range bt.List() {
+		t.Close()
+	}
+
+	err := bt.Reconnect()
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range specs {
+		t, err := bt.AddTorrentSpec(spec, nil)
+		if err != nil {
+			fmt.Println("Error add torrent after restart:", spec.InfoHash.HexString(), err)
+			continue
+		}
+		t.AddWebSeeds(seeds[spec.InfoHash])
+	}
+	return nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package torr
+
+import (
+	"time"
+
+	"server/settings"
+)
+
+const (
+	arbiterMinRate   = 32 * 1024
+	arbiterMinBuffer = int64(8 * 1024 * 1024)
+	arbiterIdleShare = 0.1
+)
+
+func (bt *BTServer) watchArbiter(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			bt.arbitrate()
+		case <-stop:
+			for _, t := range bt.List() {
+				t.setArbiterLimit(0)
+			}
+			return
+		}
+	}
+}
+
+// arbitrate gives download bandwidth to playing streams with buffer below target,
+// other playing streams are held near their bitrate and idle torrents share the rest
+func (bt *BTServer) arbitrate() {
+	bufTime := settings.Get().StreamBufferTime
+	torrents := bt.List()
+
+	starving := make(map[*Torrent]bool)
+	playing := make(map[*Torrent]float64)
+	var total float64
+	for _, t := range torrents {
+		down, _ := t.speeds()
+		total += down
+		for _, r := range t.getReaders() {
+			bitrate := r.updateBitrate()
+			if bitrate <= 0 {
+				continue
+			}
+			target := int64(bitrate * float64(bufTime))
+			if target < arbiterMinBuffer {
+				target = arbiterMinBuffer
+			}
+			if !t.hasBuffer(r, target) {
+				starving[t] = true
+			} else if bitrate > playing[t] {
+				playing[t] = bitrate
+			}
+		}
+	}
+
+	capacity := bt.updateMaxSpeed(total)
+
+	if bufTime <= 0 || len(starving) == 0 {
+		for _, t := range torrents {
+			t.setArbiterLimit(0)
+		}
+		return
+	}
+
+	if settings.Get().DownloadRateLimit > 0 {
+		capacity = float64(settings.Get().DownloadRateLimit * 1024)
+	}
+
+	idle := make([]*Torrent, 0)
+	for _, t := range torrents {
+		if starving[t] {
+			t.setArbiterLimit(0)
+		} else if bitrate, ok := playing[t]; ok {
+			t.setArbiterLimit(int(bitrate * 1.5))
+		} else {
+			idle = append(idle, t)
+		}
+	}
+	if len(idle) > 0 {
+		idleRate := int(capacity * arbiterIdleShare / float64(len(idle)))
+		if idleRate < arbiterMinRate {
+			idleRate = arbiterMinRate
+		}
+		for _, t := range idle {
+			t.setArbiterLimit(idleRate)
+		}
+	}
+}
+
+// updateMaxSpeed remembers peak of total download speed, slowly decaying, and returns it
+func (bt *BTServer) updateMaxSpeed(total float64) float64 {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	if total > bt.maxSpeed {
+		bt.maxSpeed = total
+	} else {
+		bt.maxSpeed *= 0.99
+	}
+	return bt.maxSpeed
+}
+
+func (t *Torrent) getReaders() []*Reader {
+	t.muReader.Lock()
+	defer t.muReader.Unlock()
+	readers := make([]*Reader, 0, len(t.readers))
+	for r := range t.readers {
+		if rd, ok := r.(*Reader); ok {
+			readers = append(readers, rd)
+		}
+	}
+	return readers
+}
+
+// hasBuffer reports whether completed data ahead of reader covers target or end of file
+func (t *Torrent) hasBuffer(r *Reader, target int64) bool {
+	t.muTorrent.Lock()
+	defer t.muTorrent.Unlock()
+	if t.Torrent == nil || t.Torrent.Info() == nil {
+		return true
+	}
+	pieceLength := t.Torrent.Info().PieceLength
+
+	r.mu.Lock()
+	pos := r.file.Offset() + r.pos
+	end := r.file.Offset() + r.file.Length()
+	r.mu.Unlock()
+
+	if end-pos < target {
+		target = end - pos
+	}
+	for i := int(pos / pieceLength); int64(i)*pieceLength < pos+target; i++ {
+		if !t.Torrent.PieceState(i).Complete {
+			return false
+		}
+	}
+	return true
+}
+
+// setArbiterLimit sets download limit in bytes per second, 0 - no limit,
+// download limiter of torrent gets lower of it and torrent limit
+func (t *Torrent) setArbiterLimit(limit int) {
+	if limit < 0 {
+		limit = 0
+	}
+	t.muLimits.Lock()
+	t.arbiterLimit = limit
+	t.muLimits.Unlock()
+	t.updateDownloadLimit()
+}
@@ -3,11 +3,13 @@ package torr
 import (
 	"fmt"
 	"io"
-	"path/filepath"
+	"sort"
 	"sync"
 
 	"server/settings"
 	"server/torr/storage"
+	"server/torr/storage/filecache"
+	"server/torr/storage/hybridcache"
 	"server/torr/storage/memcache"
 	"server/torr/storage/state"
 	"server/utils"
@@ -29,6 +31,16 @@ type BTServer struct {
 	wmu sync.Mutex
 
 	watching bool
+
+	stop      chan struct{}
+	maxSpeed  float64
+	forward   portForward
+	blocklist iplist.Ranger
+	banned    bannedIPs
+	lsdStop   chan struct{}
+	events    eventHub
+	snapshot  storage.Snapshotter
+	verifier  storage.Verifier
 }
 
 func NewBTS() *BTServer {
@@ -44,12 +56,36 @@ func (bt *BTServer) Connect() error {
 	bt.configure()
 	bt.client, err = torrent.NewClient(bt.config)
 	bt.torrents = make(map[metainfo.Hash]*Torrent)
+	if err == nil {
+		bt.stop = make(chan struct{})
+		go bt.watchArbiter(bt.stop)
+		if !settings.Get().DisableUPNP {
+			go bt.watchPortForward(bt.client.LocalPort(), bt.stop)
+		} else {
+			bt.forward.unmap()
+		}
+		if settings.Get().EnableLSD {
+			bt.startLSD()
+		}
+	}
 	return err
 }
 
 func (bt *BTServer) Disconnect() {
+	for _, t := range bt.List() {
+		t.stopTrackers()
+	}
 	bt.mu.Lock()
 	defer bt.mu.Unlock()
+	if bt.stop != nil {
+		close(bt.stop)
+		bt.stop = nil
+	}
+	bt.forward.unmap()
+	bt.stopLSD()
+	if bt.verifier != nil {
+		bt.verifier.StopVerify()
+	}
 	if bt.client != nil {
 		bt.client.Close()
 		bt.client = nil
@@ -63,41 +99,58 @@ func (bt *BTServer) Reconnect() error {
 }
 
 func (bt *BTServer) configure() {
-	bt.storage = memcache.NewStorage(settings.Get().CacheSize)
+	switch settings.Get().CacheType {
+	case 1:
+		bt.storage = filecache.NewStorage(settings.Get().DiskCacheSize)
+	case 2:
+		bt.storage = hybridcache.NewStorage(settings.Get().CacheSize, settings.Get().DiskCacheSize, settings.Get().CachePolicy)
+	default:
+		bt.storage = memcache.NewStorage(settings.Get().CacheSize, settings.Get().CachePolicy)
+	}
+	if n, ok := bt.storage.(storage.EvictNotifier); ok {
+		n.SetEvictHandler(bt.onEvict)
+	}
+	if r, ok := bt.storage.(storage.PositionsReceiver); ok {
+		r.SetPositionsHandler(bt.readerPositions)
+	}
+	bt.setSnapshot(bt.storage)
+	bt.verifier, _ = bt.storage.(storage.Verifier)
+	if bt.verifier != nil && settings.Get().VerifyPieces {
+		bt.verifier.StartVerify(bt.recheckPiece)
+	}
+	bt.storage = newOpenedStorage(bt, bt.storage)
 
-	blocklist, _ := iplist.MMapPackedFile(filepath.Join(settings.Path, "blocklist"))
+	bt.blocklist = loadBlocklist()
 
-	userAgent := "uTorrent/3.4.9"
-	peerID := "-UT3490-"
+	profile := GetPeerProfile(settings.Get())
 
 	bt.config = torrent.NewDefaultClientConfig()
 
-	bt.config.DisableIPv6 = true
+	bt.config.DisableIPv6 = !settings.Get().EnableIPv6
 	bt.config.DisableTCP = settings.Get().DisableTCP
 	bt.config.DisableUTP = settings.Get().DisableUTP
-	bt.config.NoDefaultPortForwarding = settings.Get().DisableUPNP
+	//Port forwarding is done by BTServer to report its state
+	bt.config.NoDefaultPortForwarding = true
+	bt.config.ListenPort = settings.Get().PeersListenPort
 	bt.config.NoDHT = settings.Get().DisableDHT
 	bt.config.NoUpload = settings.Get().DisableUpload
 	bt.config.EncryptionPolicy = torrent.EncryptionPolicy{
 		DisableEncryption: settings.Get().Encryption == 1,
 		ForceEncryption:   settings.Get().Encryption == 2,
 	}
-	bt.config.IPBlocklist = blocklist
+	bt.config.IPBlocklist = bt.ipFilter()
 	bt.config.DefaultStorage = bt.storage
-	bt.config.Bep20 = peerID
-	bt.config.PeerID = utils.PeerIDRandom(peerID)
-	bt.config.HTTPUserAgent = userAgent
+	bt.config.Bep20 = profile.Bep20
+	bt.config.PeerID = utils.PeerIDRandom(profile.PeerID)
+	bt.config.HTTPUserAgent = profile.UserAgent
 	bt.config.EstablishedConnsPerTorrent = settings.Get().ConnectionsLimit
 
 	bt.config.TorrentPeersHighWater = 3000
 	bt.config.HalfOpenConnsPerTorrent = 50
 
-	if settings.Get().DownloadRateLimit > 0 {
-		bt.config.DownloadRateLimiter = utils.Limit(settings.Get().DownloadRateLimit * 1024)
-	}
-	if settings.Get().UploadRateLimit > 0 {
-		bt.config.UploadRateLimiter = utils.Limit(settings.Get().UploadRateLimit * 1024)
-	}
+	//Limiters are always set, to change them on running client
+	bt.config.DownloadRateLimiter = utils.Limit(settings.Get().DownloadRateLimit * 1024)
+	bt.config.UploadRateLimiter = utils.Limit(settings.Get().UploadRateLimit * 1024)
 
 	//bt.config.Debug = true
 
@@ -105,10 +158,19 @@ func (bt *BTServer) configure() {
 }
 
 func (bt *BTServer) AddTorrent(magnet metainfo.Magnet, onAdd func(*Torrent)) (*Torrent, error) {
-	torr, err := NewTorrent(magnet, bt)
+	return bt.AddTorrentSpec(&torrent.TorrentSpec{
+		Trackers:    [][]string{magnet.Trackers},
+		DisplayName: magnet.DisplayName,
+		InfoHash:    magnet.InfoHash,
+	}, onAdd)
+}
+
+func (bt *BTServer) AddTorrentSpec(spec *torrent.TorrentSpec, onAdd func(*Torrent)) (*Torrent, error) {
+	torr, err := NewTorrent(spec, bt)
 	if err != nil {
 		return nil, err
 	}
+	bt.dropExpired(torr)
 
 	if onAdd != nil {
 		go func() {
@@ -123,6 +185,32 @@ func (bt *BTServer) AddTorrent(magnet metainfo.Magnet, onAdd func(*Torrent)) (*T
 	return torr, nil
 }
 
+// dropExpired closes least recently used torrents over open torrents limit
+func (bt *BTServer) dropExpired(keep *Torrent) {
+	max := settings.Get().Expire.MaxTorrents
+	if max <= 0 {
+		return
+	}
+	list := bt.List()
+	if len(list) <= max {
+		return
+	}
+	candidates := make([]*Torrent, 0)
+	for _, t := range list {
+		//Torrents without info aren't saved to db yet
+		if t != keep && !t.pinned && t.readersCount() == 0 && t.hasInfo() {
+			candidates = append(candidates, t)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastAccess.Before(candidates[j].lastAccess)
+	})
+	for i := 0; i < len(list)-max && i < len(candidates); i++ {
+		fmt.Println("Drop torrent over limit:", candidates[i].Hash().HexString())
+		candidates[i].Close()
+	}
+}
+
 func (bt *BTServer) List() []*Torrent {
 	bt.mu.Lock()
 	defer bt.mu.Unlock()
@@ -156,8 +244,12 @@ func (bt *BTServer) BTState() *BTState {
 
 	btState := new(BTState)
 	btState.LocalPort = bt.client.LocalPort()
+	btState.PortForward = bt.forward.State()
 	btState.PeerID = fmt.Sprintf("%x", bt.client.PeerID())
-	btState.BannedIPs = len(bt.client.BadPeerIPs())
+	btState.BannedIPs = len(bt.client.BadPeerIPs()) + bt.banned.Len()
+	if bt.blocklist != nil {
+		btState.BlocklistRanges = bt.blocklist.NumRanges()
+	}
 	for _, dht := range bt.client.DhtServers() {
 		btState.DHTs = append(btState.DHTs, dht)
 	}
@@ -0,0 +1,219 @@
+package torr
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/settings"
+
+	"github.com/anacrolix/torrent/iplist"
+)
+
+// blocklistClient downloads blocklist, timeout covers reading of whole list
+var blocklistClient = &http.Client{Timeout: time.Minute * 2}
+
+func blocklistPath() string {
+	return filepath.Join(settings.Path, "blocklist")
+}
+
+// loadBlocklist maps packed blocklist, nil if there is no blocklist
+func loadBlocklist() iplist.Ranger {
+	blocklist, err := iplist.MMapPackedFile(blocklistPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println("Error load blocklist:", err)
+		}
+		return nil
+	}
+	return blocklist
+}
+
+// ImportBlocklist reads P2P, DAT or eMule blocklist from file path or url,
+// saves it in packed format and applies it to running client
+func (bt *BTServer) ImportBlocklist(src string) (int, error) {
+	r, err := openBlocklist(src)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	ranges, err := parseBlocklist(r)
+	if err != nil {
+		return 0, err
+	}
+	if len(ranges) == 0 {
+		return 0, errors.New("blocklist is empty")
+	}
+
+	tmp := blocklistPath() + ".tmp"
+	ff, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	err = iplist.New(ranges).WritePacked(ff)
+	ff.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	//Mapped old file stays valid after rename
+	err = os.Rename(tmp, blocklistPath())
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	blocklist := loadBlocklist()
+	if blocklist == nil {
+		return 0, errors.New("error load converted blocklist")
+	}
+	bt.SetBlocklist(blocklist)
+	fmt.Println("Blocklist loaded:", blocklist.NumRanges(), "ranges")
+	return blocklist.NumRanges(), nil
+}
+
+// ClearBlocklist removes blocklist from disk and running client
+func (bt *BTServer) ClearBlocklist() error {
+	err := os.Remove(blocklistPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	bt.SetBlocklist(nil)
+	return nil
+}
+
+func (bt *BTServer) SetBlocklist(blocklist iplist.Ranger) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	bt.blocklist = blocklist
+	filter := bt.ipFilter()
+	if bt.config != nil {
+		bt.config.IPBlocklist = filter
+	}
+	if bt.client != nil {
+		bt.client.SetIPBlockList(filter)
+	}
+}
+
+func openBlocklist(src string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := blocklistClient.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.New("error download blocklist: " + resp.Status)
+		}
+		rc = resp.Body
+	} else {
+		ff, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		rc = ff
+	}
+
+	br := bufio.NewReader(rc)
+	magic, _ := br.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		return readCloser{gz, rc}, nil
+	}
+	return readCloser{br, rc}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// parseBlocklist parses lines of P2P format "desc:first-last"
+// and DAT/eMule format "first - last , level , desc"
+func parseBlocklist(r io.Reader) ([]iplist.Range, error) {
+	var ranges []iplist.Range
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' || bytes.HasPrefix(line, []byte("//")) {
+			continue
+		}
+		rng, ok := parseDatLine(string(line))
+		if !ok {
+			var err error
+			rng, ok, err = iplist.ParseBlocklistP2PLine(line)
+			if err != nil || !ok {
+				continue
+			}
+		}
+		ranges = append(ranges, rng)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return bytes.Compare(ranges[i].First.To16(), ranges[j].First.To16()) < 0
+	})
+	return ranges, nil
+}
+
+func parseDatLine(line string) (iplist.Range, bool) {
+	fields := strings.SplitN(line, ",", 3)
+	ips := strings.SplitN(fields[0], "-", 2)
+	if len(ips) != 2 {
+		return iplist.Range{}, false
+	}
+	first := parseIP(ips[0])
+	last := parseIP(ips[1])
+	if first == nil || last == nil {
+		return iplist.Range{}, false
+	}
+	//Level above 127 means allowed range
+	if len(fields) > 1 {
+		level, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+		if err == nil && level > 127 {
+			return iplist.Range{}, false
+		}
+	}
+	rng := iplist.Range{First: first, Last: last}
+	if len(fields) > 2 {
+		rng.Description = strings.TrimSpace(fields[2])
+	}
+	return rng, true
+}
+
+// parseIP parses ip with leading zeros, like 001.009.096.105
+func parseIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	parts := strings.Split(s, ".")
+	if len(parts) != 4 {
+		return net.ParseIP(s)
+	}
+	ip := make(net.IP, 4)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || n > 255 {
+			return nil
+		}
+		ip[i] = byte(n)
+	}
+	return ip.To16()
+}
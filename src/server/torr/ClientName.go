@@ -0,0 +1,48 @@
+package torr
+
+import "strings"
+
+var azureusClients = map[string]string{
+	"AZ": "Vuze",
+	"BC": "BitComet",
+	"BT": "BitTorrent",
+	"DE": "Deluge",
+	"FG": "FlashGet",
+	"KT": "KTorrent",
+	"LT": "libtorrent",
+	"lt": "libTorrent",
+	"qB": "qBittorrent",
+	"SD": "Thunder",
+	"TR": "Transmission",
+	"TS": "TorrServer",
+	"UT": "µTorrent",
+	"UM": "µTorrent Mac",
+	"UW": "µTorrent Web",
+	"XL": "Xunlei",
+	"BI": "BiglyBT",
+	"FD": "Free Download Manager",
+	"LW": "LimeWire",
+	"WW": "WebTorrent",
+	"GR": "GetRight",
+	"PI": "PicoTorrent",
+	"TX": "Tixati",
+}
+
+// ClientName returns client name and version from azureus style peer id, like -UT3490-
+func ClientName(peerID string) string {
+	if len(peerID) >= 8 && peerID[0] == '-' && peerID[7] == '-' {
+		name, ok := azureusClients[peerID[1:3]]
+		if !ok {
+			name = peerID[1:3]
+		}
+		ver := make([]string, 0, 4)
+		for _, c := range peerID[3:7] {
+			ver = append(ver, string(c))
+		}
+		return name + " " + strings.Join(ver, ".")
+	}
+	if strings.HasPrefix(peerID, "M") && len(peerID) >= 8 {
+		return "BitTorrent " + strings.Trim(peerID[1:8], "-")
+	}
+	return ""
+}
@@ -0,0 +1,137 @@
+package torr
+
+import (
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	EventAdded   = "added"
+	EventInfo    = "info"
+	EventStatus  = "status"
+	EventPreload = "preload"
+	EventReader  = "reader"
+	EventEvict   = "evict"
+	EventClosed  = "closed"
+)
+
+type Event struct {
+	Type string
+	Hash string
+	Time int64 // unix time in milliseconds
+
+	Status string `json:",omitempty"`
+	Reason string `json:",omitempty"`
+
+	Readers   int  `json:",omitempty"`
+	Connected bool `json:",omitempty"` // reader connected, else disconnected
+
+	PreloadedBytes int64   `json:",omitempty"`
+	PreloadSize    int64   `json:",omitempty"`
+	DownloadSpeed  float64 `json:",omitempty"`
+
+	Pieces []int `json:",omitempty"` // evicted pieces
+}
+
+type eventHub struct {
+	subs map[chan Event]struct{}
+	mu   sync.Mutex
+}
+
+// Subscribe returns channel of torrents events, it must be released by Unsubscribe
+func (bt *BTServer) Subscribe() chan Event {
+	bt.events.mu.Lock()
+	defer bt.events.mu.Unlock()
+	if bt.events.subs == nil {
+		bt.events.subs = make(map[chan Event]struct{})
+	}
+	ch := make(chan Event, 64)
+	bt.events.subs[ch] = struct{}{}
+	return ch
+}
+
+func (bt *BTServer) Unsubscribe(ch chan Event) {
+	bt.events.mu.Lock()
+	defer bt.events.mu.Unlock()
+	delete(bt.events.subs, ch)
+}
+
+// publish sends event to subscribers, slow subscribers lose events
+func (bt *BTServer) publish(ev Event) {
+	bt.events.mu.Lock()
+	defer bt.events.mu.Unlock()
+	for ch := range bt.events.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func (bt *BTServer) onEvict(hash metainfo.Hash, piece int) {
+	//Called from storage under its locks
+	go func() {
+		if t := bt.GetTorrent(hash); t != nil {
+			t.muEvents.Lock()
+			t.evicted = append(t.evicted, piece)
+			t.muEvents.Unlock()
+		}
+	}()
+}
+
+// recheckPiece makes torrent check piece changed by storage, corrupted piece is removed
+// and downloaded again, piece loaded from snapshot becomes complete
+func (bt *BTServer) recheckPiece(hash metainfo.Hash, piece int) {
+	go func() {
+		if t := bt.GetTorrent(hash); t != nil {
+			t.muTorrent.Lock()
+			if t.Torrent != nil {
+				t.Torrent.Piece(piece).VerifyData()
+			}
+			t.muTorrent.Unlock()
+		}
+	}()
+}
+
+// event queues event of torrent, it is sent from watch
+func (t *Torrent) event(ev Event) {
+	select {
+	case t.events <- t.newEvent(ev):
+	default:
+	}
+}
+
+// watchEvents sends changes of torrent found on progress tick
+func (t *Torrent) watchEvents() {
+	if t.status != t.lastStatus {
+		t.lastStatus = t.status
+		t.bt.publish(t.newEvent(Event{
+			Type:   EventStatus,
+			Status: t.status.String(),
+			Reason: t.FailReason(),
+		}))
+	}
+	if t.status == TorrentPreload {
+		t.bt.publish(t.newEvent(Event{
+			Type:           EventPreload,
+			PreloadedBytes: t.PreloadedBytes,
+			PreloadSize:    t.preloadSize(),
+			DownloadSpeed:  t.DownloadSpeed,
+		}))
+	}
+	t.muEvents.Lock()
+	evicted := t.evicted
+	t.evicted = nil
+	t.muEvents.Unlock()
+	if len(evicted) > 0 {
+		t.bt.publish(t.newEvent(Event{Type: EventEvict, Pieces: evicted}))
+	}
+}
+
+func (t *Torrent) newEvent(ev Event) Event {
+	ev.Hash = t.hash.HexString()
+	ev.Time = time.Now().UnixNano() / int64(time.Millisecond)
+	return ev
+}
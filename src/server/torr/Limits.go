@@ -0,0 +1,116 @@
+package torr
+
+import (
+	"server/settings"
+	"server/utils"
+)
+
+type Limits struct {
+	DownloadRateLimit int // in kb, 0 - inf
+	UploadRateLimit   int // in kb, 0 - inf
+	ConnectionsLimit  int // 0 - from settings
+}
+
+const (
+	limitMinConns = 2
+	limitOver     = 1.1 // upload speed over limit, when connections are dropped
+	limitUnder    = 0.8 // upload speed under limit, when connection is added
+)
+
+func (t *Torrent) SetLimits(dl, ul, conns int) {
+	t.muLimits.Lock()
+	t.limits = &Limits{
+		DownloadRateLimit: dl,
+		UploadRateLimit:   ul,
+		ConnectionsLimit:  conns,
+	}
+	t.limitConns = 0
+	t.muLimits.Unlock()
+	t.updateDownloadLimit()
+	t.applyLimits()
+}
+
+func (t *Torrent) Limits() Limits {
+	t.muLimits.Lock()
+	defer t.muLimits.Unlock()
+	if t.limits == nil {
+		return Limits{}
+	}
+	return *t.limits
+}
+
+// downloadLimit returns lower of torrent and arbiter download limits in bytes per second, 0 - no limit
+func (t *Torrent) downloadLimit() int {
+	t.muLimits.Lock()
+	defer t.muLimits.Unlock()
+	limit := t.arbiterLimit
+	if t.limits != nil && t.limits.DownloadRateLimit > 0 && (limit <= 0 || t.limits.DownloadRateLimit*1024 < limit) {
+		limit = t.limits.DownloadRateLimit * 1024
+	}
+	return limit
+}
+
+// updateDownloadLimit sets rate of download limiter, it is waited by storage on every write
+func (t *Torrent) updateDownloadLimit() {
+	utils.SetLimit(t.download, t.downloadLimit())
+}
+
+// applyLimits keeps upload speed under limit by count of connected peers.
+// Client reads uploaded chunks from storage under its lock, so upload can't be
+// throttled in storage like download. It is called every second after speed was measured
+func (t *Torrent) applyLimits() {
+	l := t.Limits()
+	maxConns := settings.Get().ConnectionsLimit
+	if l.ConnectionsLimit > 0 {
+		maxConns = l.ConnectionsLimit
+	}
+	ul := float64(l.UploadRateLimit * 1024)
+	_, upSpeed := t.speeds()
+
+	t.muLimits.Lock()
+	conns := maxConns
+	if ul > 0 {
+		conns = t.limitConns
+		if conns <= 0 {
+			conns = maxConns
+		}
+		if upSpeed > ul*limitOver {
+			conns = int(float64(conns) * ul / upSpeed)
+		} else if upSpeed < ul*limitUnder {
+			conns++
+		}
+		if conns > maxConns {
+			conns = maxConns
+		}
+		if conns < limitMinConns {
+			conns = limitMinConns
+		}
+	}
+	changed := conns != t.limitConns
+	t.limitConns = conns
+	t.muLimits.Unlock()
+
+	if changed {
+		t.muTorrent.Lock()
+		if t.Torrent != nil {
+			t.Torrent.SetMaxEstablishedConns(conns)
+		}
+		t.muTorrent.Unlock()
+	}
+}
+
+// loadDB applies limits, pin, trackers and web seeds saved with torrent
+func (t *Torrent) loadDB() {
+	torrDb, err := settings.LoadTorrentDB(t.hash.HexString())
+	if err != nil || torrDb == nil {
+		return
+	}
+	t.pinned = torrDb.Pinned
+	if torrDb.Trackers != nil {
+		t.setOwnTrackers(torrDb.Trackers)
+	}
+	t.AddWebSeeds(torrDb.WebSeeds)
+	if torrDb.DownloadRateLimit > 0 || torrDb.UploadRateLimit > 0 || torrDb.ConnectionsLimit > 0 {
+		t.SetLimits(torrDb.DownloadRateLimit, torrDb.UploadRateLimit, torrDb.ConnectionsLimit)
+	}
+}
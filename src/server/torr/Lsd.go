@@ -0,0 +1,175 @@
+package torr
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Local service discovery, BEP 14
+const (
+	lsdAddr4    = "239.192.152.143:6771"
+	lsdAddr6    = "[ff15::efc0:988f]:6771"
+	lsdInterval = time.Minute * 5
+	lsdMinDelay = time.Minute
+)
+
+type lsdService struct {
+	bt     *BTServer
+	cookie string
+	conns  []*net.UDPConn
+	addrs  []*net.UDPAddr
+
+	announced map[metainfo.Hash]time.Time
+}
+
+// startLSD starts local service discovery, bt.mu must be locked
+func (bt *BTServer) startLSD() {
+	if bt.lsdStop != nil || bt.client == nil {
+		return
+	}
+	bt.lsdStop = make(chan struct{})
+	go bt.watchLSD(bt.client.LocalPort(), !bt.config.DisableIPv6, bt.lsdStop)
+}
+
+// stopLSD stops local service discovery, bt.mu must be locked
+func (bt *BTServer) stopLSD() {
+	if bt.lsdStop != nil {
+		close(bt.lsdStop)
+		bt.lsdStop = nil
+	}
+}
+
+// watchLSD announces open torrents to local network and adds local peers
+func (bt *BTServer) watchLSD(port int, ipv6 bool, stop <-chan struct{}) {
+	lsd := &lsdService{
+		bt:        bt,
+		cookie:    strconv.FormatInt(rand.Int63(), 36),
+		announced: make(map[metainfo.Hash]time.Time),
+	}
+	networks := map[string]string{"udp4": lsdAddr4}
+	if ipv6 {
+		networks["udp6"] = lsdAddr6
+	}
+	for network, addr := range networks {
+		gaddr, err := net.ResolveUDPAddr(network, addr)
+		if err != nil {
+			continue
+		}
+		conn, err := net.ListenMulticastUDP(network, nil, gaddr)
+		if err != nil {
+			fmt.Println("Error start LSD on", addr, err)
+			continue
+		}
+		lsd.conns = append(lsd.conns, conn)
+		lsd.addrs = append(lsd.addrs, gaddr)
+		go lsd.listen(conn)
+	}
+	if len(lsd.conns) == 0 {
+		return
+	}
+	defer func() {
+		for _, conn := range lsd.conns {
+			conn.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+	for {
+		lsd.announce(port)
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// announce sends announce for torrents, that weren't announced for interval,
+// new torrents are announced on next tick
+func (lsd *lsdService) announce(port int) {
+	open := make(map[metainfo.Hash]bool)
+	var hashes []string
+	for _, t := range lsd.bt.List() {
+		open[t.hash] = true
+		if last, ok := lsd.announced[t.hash]; ok && time.Since(last) < lsdInterval {
+			continue
+		}
+		lsd.announced[t.hash] = time.Now()
+		hashes = append(hashes, t.hash.HexString())
+	}
+	for hash := range lsd.announced {
+		if !open[hash] {
+			delete(lsd.announced, hash)
+		}
+	}
+	if len(hashes) == 0 {
+		return
+	}
+
+	for i, conn := range lsd.conns {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "BT-SEARCH * HTTP/1.1\r\n")
+		fmt.Fprintf(&buf, "Host: %s\r\n", lsd.addrs[i].String())
+		fmt.Fprintf(&buf, "Port: %d\r\n", port)
+		for _, hash := range hashes {
+			fmt.Fprintf(&buf, "Infohash: %s\r\n", hash)
+		}
+		fmt.Fprintf(&buf, "cookie: %s\r\n\r\n\r\n", lsd.cookie)
+		_, err := conn.WriteToUDP(buf.Bytes(), lsd.addrs[i])
+		if err != nil {
+			fmt.Println("Error send LSD announce:", err)
+		}
+	}
+}
+
+func (lsd *lsdService) listen(conn *net.UDPConn) {
+	buf := make([]byte, 1500)
+	lastAdd := make(map[string]time.Time)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "BT-SEARCH" {
+			continue
+		}
+		if req.Header.Get("cookie") == lsd.cookie {
+			continue
+		}
+		port, err := strconv.Atoi(req.Header.Get("Port"))
+		if err != nil || port <= 0 || port > 65535 {
+			continue
+		}
+		addr := net.JoinHostPort(from.IP.String(), strconv.Itoa(port))
+		for _, ih := range req.Header["Infohash"] {
+			ih = strings.TrimSpace(ih)
+			if len(ih) != 40 {
+				continue
+			}
+			tor := lsd.bt.GetTorrent(metainfo.NewHashFromHex(ih))
+			if tor == nil {
+				continue
+			}
+			if len(lastAdd) > 1000 {
+				lastAdd = make(map[string]time.Time)
+			}
+			key := ih + addr
+			if last, ok := lastAdd[key]; ok && time.Since(last) < lsdMinDelay {
+				continue
+			}
+			lastAdd[key] = time.Now()
+			tor.AddPeer(addr)
+		}
+	}
+}
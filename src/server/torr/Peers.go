@@ -0,0 +1,175 @@
+package torr
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/iplist"
+)
+
+type PeerInfo struct {
+	Addr   string
+	PeerID string
+	Client string
+
+	UTP      bool
+	Incoming bool
+	Source   string // I - incoming, T - tracker, Hg/Ha - dht, X - pex
+
+	Pieces      int // pieces peer has
+	PiecesTotal int
+	Completed   float64 // in percent
+
+	DownloadRate float64 // in bytes per second
+}
+
+var errPeerAddress = errors.New("wrong peer address")
+
+// Peers returns connected peers of torrent
+func (t *Torrent) Peers() []PeerInfo {
+	t.muTorrent.Lock()
+	defer t.muTorrent.Unlock()
+	if t.Torrent == nil {
+		return nil
+	}
+	total := 0
+	if t.Torrent.Info() != nil {
+		total = t.Torrent.NumPieces()
+	}
+	conns := t.Torrent.PeerConns()
+	peers := make([]PeerInfo, 0, len(conns))
+	for _, pc := range conns {
+		peerID := string(pc.PeerID[:])
+		peer := PeerInfo{
+			Addr:         pc.RemoteAddr.String(),
+			PeerID:       peerID,
+			Client:       ClientName(peerID),
+			UTP:          strings.Contains(pc.Network, "utp"),
+			Incoming:     pc.Discovery == torrent.PeerSourceIncoming,
+			Source:       string(pc.Discovery),
+			Pieces:       int(pc.PeerPieces().GetCardinality()),
+			PiecesTotal:  total,
+			DownloadRate: pc.DownloadRate(),
+		}
+		if total > 0 {
+			peer.Completed = float64(peer.Pieces) * 100 / float64(total)
+		}
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// AddPeer adds peer by address host:port to torrent
+func (t *Torrent) AddPeer(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	p, err := strconv.Atoi(port)
+	if ip == nil || err != nil || p <= 0 || p > 65535 {
+		return errPeerAddress
+	}
+
+	t.muTorrent.Lock()
+	defer t.muTorrent.Unlock()
+	if t.Torrent == nil {
+		return errors.New("torrent closed")
+	}
+	t.Torrent.AddPeers([]torrent.Peer{{IP: ip, Port: p}})
+	return nil
+}
+
+// BanIP blocks ip until server exit, new connections from ip are refused
+// by blocklist of client, connected peer stays until it disconnects
+func (bt *BTServer) BanIP(ip net.IP) {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	bt.banned.Ban(ip)
+	filter := bt.ipFilter()
+	if bt.config != nil {
+		bt.config.IPBlocklist = filter
+	}
+	if bt.client != nil {
+		bt.client.SetIPBlockList(filter)
+	}
+}
+
+func (bt *BTServer) BannedIPs() []string {
+	return bt.banned.List()
+}
+
+// ipFilter returns blocklist with ips banned in session
+func (bt *BTServer) ipFilter() iplist.Ranger {
+	if bt.banned.Len() == 0 {
+		return bt.blocklist
+	}
+	return &banList{bt.blocklist, bt.banned.Copy()}
+}
+
+type bannedIPs struct {
+	ips map[string]struct{}
+	mu  sync.Mutex
+}
+
+func (b *bannedIPs) Ban(ip net.IP) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.ips == nil {
+		b.ips = make(map[string]struct{})
+	}
+	b.ips[ip.String()] = struct{}{}
+}
+
+func (b *bannedIPs) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.ips)
+}
+
+func (b *bannedIPs) List() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	list := make([]string, 0, len(b.ips))
+	for ip := range b.ips {
+		list = append(list, ip)
+	}
+	return list
+}
+
+func (b *bannedIPs) Copy() map[string]struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ips := make(map[string]struct{}, len(b.ips))
+	for ip := range b.ips {
+		ips[ip] = struct{}{}
+	}
+	return ips
+}
+
+type banList struct {
+	blocklist iplist.Ranger
+	banned    map[string]struct{}
+}
+
+func (b *banList) Lookup(ip net.IP) (iplist.Range, bool) {
+	if _, ok := b.banned[ip.String()]; ok {
+		return iplist.Range{First: ip, Last: ip, Description: "banned"}, true
+	}
+	if b.blocklist != nil {
+		return b.blocklist.Lookup(ip)
+	}
+	return iplist.Range{}, false
+}
+
+func (b *banList) NumRanges() int {
+	n := len(b.banned)
+	if b.blocklist != nil {
+		n += b.blocklist.NumRanges()
+	}
+	return n
+}
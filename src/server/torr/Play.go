@@ -31,12 +31,12 @@ func (bt *BTServer) View(torr *Torrent, file *torrent.File, c echo.Context) erro
 func (bt *BTServer) Play(torr *Torrent, file *torrent.File, preload int64, c echo.Context) error {
 	if torr.status == TorrentAdded {
 		if !torr.GotInfo() {
-			return echo.NewHTTPError(http.StatusBadRequest, "torrent closed befor get info")
+			return echo.NewHTTPError(http.StatusGatewayTimeout, torr.InfoError())
 		}
 	}
-	if torr.status == TorrentGettingInfo {
+	if torr.status == TorrentGettingInfo || torr.status == TorrentFailed {
 		if !torr.WaitInfo() {
-			return echo.NewHTTPError(http.StatusBadRequest, "torrent closed befor get info")
+			return echo.NewHTTPError(http.StatusGatewayTimeout, torr.InfoError())
 		}
 	}
 
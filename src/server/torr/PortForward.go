@@ -0,0 +1,152 @@
+package torr
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/upnp"
+	"github.com/jackpal/gateway"
+	natpmp "github.com/jackpal/go-nat-pmp"
+)
+
+const portForwardRenew = time.Minute * 30
+
+type PortForwardState struct {
+	Port       int
+	UPnPPort   int // external port mapped by UPnP
+	NATPMPPort int // external port mapped by NAT-PMP
+	UPnP       string
+	NATPMP     string
+	Updated    time.Time
+}
+
+type portForward struct {
+	state PortForwardState
+	mu    sync.Mutex
+
+	//Functions, that remove mappings made on router, by protocol
+	unmaps map[string]func()
+}
+
+func (p *portForward) State() PortForwardState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// unmap removes port mappings from router and resets state
+func (p *portForward) unmap() {
+	p.mu.Lock()
+	unmaps := p.unmaps
+	p.unmaps = nil
+	p.state = PortForwardState{}
+	p.mu.Unlock()
+	for _, fn := range unmaps {
+		fn()
+	}
+}
+
+// update changes state of working forwarding and keeps function, that removes mapping,
+// mapping made after forwarding stopped is removed at once
+func (p *portForward) update(stop <-chan struct{}, proto string, unmap func(), fn func(st *PortForwardState)) {
+	p.mu.Lock()
+	select {
+	case <-stop:
+		p.mu.Unlock()
+		if unmap != nil {
+			unmap()
+		}
+		return
+	default:
+	}
+	if unmap != nil {
+		if p.unmaps == nil {
+			p.unmaps = make(map[string]func())
+		}
+		p.unmaps[proto] = unmap
+	}
+	fn(&p.state)
+	p.state.Updated = time.Now()
+	p.mu.Unlock()
+}
+
+// watchPortForward maps listen port on router by UPnP and NAT-PMP and renews mapping,
+// mapping is removed by unmap on disconnect
+func (bt *BTServer) watchPortForward(port int, stop <-chan struct{}) {
+	bt.forward.update(stop, "", nil, func(st *PortForwardState) {
+		*st = PortForwardState{Port: port, UPnP: "searching", NATPMP: "searching"}
+	})
+	ticker := time.NewTicker(portForwardRenew)
+	defer ticker.Stop()
+	for {
+		go bt.forwardUPnP(port, stop)
+		go bt.forwardNATPMP(port, stop)
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (bt *BTServer) forwardUPnP(port int, stop <-chan struct{}) {
+	devices := upnp.Discover(0, 2*time.Second)
+	if len(devices) == 0 {
+		bt.forward.update(stop, "upnp", nil, func(st *PortForwardState) { st.UPnP = "no devices" })
+		return
+	}
+	var lastErr error
+	for _, d := range devices {
+		ext, err := d.AddPortMapping(upnp.TCP, port, port, "TorrServer", 0)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		extUDP, err := d.AddPortMapping(upnp.UDP, port, port, "TorrServer", 0)
+		if err != nil {
+			d.DeletePortMapping(upnp.TCP, ext)
+			lastErr = err
+			continue
+		}
+		dev := d
+		unmap := func() {
+			dev.DeletePortMapping(upnp.TCP, ext)
+			dev.DeletePortMapping(upnp.UDP, extUDP)
+		}
+		bt.forward.update(stop, "upnp", unmap, func(st *PortForwardState) {
+			st.UPnP = "ok"
+			st.UPnPPort = ext
+		})
+		return
+	}
+	fmt.Println("Error UPnP port mapping:", lastErr)
+	bt.forward.update(stop, "upnp", nil, func(st *PortForwardState) { st.UPnP = "error: " + lastErr.Error() })
+}
+
+func (bt *BTServer) forwardNATPMP(port int, stop <-chan struct{}) {
+	gw, err := gateway.DiscoverGateway()
+	if err != nil {
+		bt.forward.update(stop, "natpmp", nil, func(st *PortForwardState) { st.NATPMP = "no gateway" })
+		return
+	}
+	client := natpmp.NewClient(gw)
+	lifetime := int(portForwardRenew.Seconds() * 2)
+	res, err := client.AddPortMapping("tcp", port, port, lifetime)
+	if err == nil {
+		_, err = client.AddPortMapping("udp", port, port, lifetime)
+	}
+	if err != nil {
+		bt.forward.update(stop, "natpmp", nil, func(st *PortForwardState) { st.NATPMP = "error: " + err.Error() })
+		return
+	}
+	//Mapping with zero lifetime removes it
+	unmap := func() {
+		client.AddPortMapping("tcp", port, 0, 0)
+		client.AddPortMapping("udp", port, 0, 0)
+	}
+	bt.forward.update(stop, "natpmp", unmap, func(st *PortForwardState) {
+		st.NATPMP = "ok"
+		st.NATPMPPort = int(res.MappedExternalPort)
+	})
+}
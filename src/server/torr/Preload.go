@@ -0,0 +1,28 @@
+package torr
+
+import (
+	"fmt"
+
+	"github.com/anacrolix/torrent"
+	"github.com/labstack/gommon/bytes"
+)
+
+// planPreload reads container layout of file and returns index regions, that are not in preload start,
+// index regions of readers are set by findIndexRegions
+func (t *Torrent) planPreload(file *torrent.File, size int64) []Region {
+	regions, err := t.readIndex(file)
+	if err != nil {
+		fmt.Println("Preload plan:", file.Path(), err)
+		return nil
+	}
+
+	idx := make([]Region, 0)
+	for _, reg := range regions {
+		if reg.Offset+reg.Length <= size {
+			continue
+		}
+		fmt.Println("Preload region:", reg.Name, reg.Offset, bytes.Format(reg.Length))
+		idx = append(idx, Region{reg.Offset, reg.Length})
+	}
+	return idx
+}
@@ -0,0 +1,51 @@
+package torr
+
+import (
+	"strings"
+
+	"server/settings"
+	"server/version"
+)
+
+type PeerProfile struct {
+	PeerID    string
+	Bep20     string
+	UserAgent string
+}
+
+var peerProfiles = map[string]PeerProfile{
+	"utorrent":     {"-UT3490-", "-UT3490-", "uTorrent/3.4.9"},
+	"qbittorrent":  {"-qB4110-", "-qB4110-", "qBittorrent/4.1.1"},
+	"transmission": {"-TR2940-", "-TR2940-", "Transmission/2.94"},
+	"deluge":       {"-DE13F0-", "-DE13F0-", "Deluge 1.3.15"},
+	"torrserver":   torrServerProfile(),
+}
+
+// torrServerProfile makes honest peer id like -TS1065- from version
+func torrServerProfile() PeerProfile {
+	ver := strings.Replace(version.Version, ".", "", -1)
+	ver = (ver + "0000")[:4]
+	return PeerProfile{"-TS" + ver + "-", "-TS" + ver + "-", "TorrServer/" + version.Version}
+}
+
+// GetPeerProfile returns peer identity from settings, unknown profile falls back to utorrent
+func GetPeerProfile(sets *settings.Settings) PeerProfile {
+	if sets.PeerProfile == "custom" {
+		prof := PeerProfile{sets.PeerIDPrefix, sets.PeerBep20, sets.PeerUserAgent}
+		def := peerProfiles["utorrent"]
+		if prof.PeerID == "" || len(prof.PeerID) > 20 {
+			prof.PeerID = def.PeerID
+		}
+		if prof.Bep20 == "" {
+			prof.Bep20 = prof.PeerID
+		}
+		if prof.UserAgent == "" {
+			prof.UserAgent = def.UserAgent
+		}
+		return prof
+	}
+	if prof, ok := peerProfiles[sets.PeerProfile]; ok {
+		return prof
+	}
+	return peerProfiles["utorrent"]
+}
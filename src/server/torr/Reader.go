@@ -0,0 +1,318 @@
+package torr
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"server/torr/container"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+type readerTier int
+
+const (
+	tierNone = readerTier(iota)
+	tierBackground
+	tierIndex
+	tierReadahead
+	tierNext
+	tierNow
+)
+
+const nextPieces = 2
+
+// Region is a byte range in file, offset is from file start
+type Region struct {
+	Offset int64
+	Length int64
+}
+
+// Reader keeps pieces priorities around reader position
+type Reader struct {
+	torrent.Reader
+
+	file        *torrent.File
+	t           *Torrent
+	pieceLength int64
+
+	mu        sync.Mutex
+	pos       int64
+	readahead int64
+	lastPiece int
+
+	readBytes int64
+	lastRead  time.Time
+	lastBytes int64
+	lastTick  time.Time
+	bitrate   float64
+}
+
+func newReader(t *Torrent, file *torrent.File, readahead int64) *Reader {
+	r := new(Reader)
+	r.Reader = file.NewReader()
+	r.file = file
+	r.t = t
+	r.pieceLength = file.Torrent().Info().PieceLength
+	r.lastPiece = -1
+	r.SetReadahead(readahead)
+	return r
+}
+
+func (r *Reader) Read(b []byte) (n int, err error) {
+	n, err = r.Reader.Read(b)
+	r.mu.Lock()
+	r.pos += int64(n)
+	if n > 0 {
+		r.readBytes += int64(n)
+		r.lastRead = time.Now()
+	}
+	changed := r.moved()
+	r.mu.Unlock()
+	if changed {
+		r.t.schedulePriorities()
+	}
+	return
+}
+
+func (r *Reader) Seek(off int64, whence int) (ret int64, err error) {
+	ret, err = r.Reader.Seek(off, whence)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	r.pos = ret
+	changed := r.moved()
+	r.mu.Unlock()
+	if changed {
+		r.t.schedulePriorities()
+	}
+	return
+}
+
+func (r *Reader) SetReadahead(readahead int64) {
+	r.Reader.SetReadahead(readahead)
+	r.mu.Lock()
+	r.readahead = readahead
+	r.mu.Unlock()
+	r.t.schedulePriorities()
+}
+
+// updateBitrate returns smoothed read speed of reader, 0 if reader is not playing
+func (r *Reader) updateBitrate() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	delta := r.readBytes - r.lastBytes
+	dt := now.Sub(r.lastTick).Seconds()
+	first := r.lastTick.IsZero()
+	r.lastBytes = r.readBytes
+	r.lastTick = now
+	if first || dt <= 0 || now.Sub(r.lastRead) > time.Second*10 {
+		r.bitrate = 0
+		return 0
+	}
+	cur := float64(delta) / dt
+	if r.bitrate == 0 {
+		r.bitrate = cur
+	} else {
+		r.bitrate = r.bitrate*0.9 + cur*0.1
+	}
+	return r.bitrate
+}
+
+// moved reports whether reader went to other piece, r.mu must be locked
+func (r *Reader) moved() bool {
+	piece := int((r.file.Offset() + r.pos) / r.pieceLength)
+	if piece != r.lastPiece {
+		r.lastPiece = piece
+		return true
+	}
+	return false
+}
+
+func (r *Reader) tiers(pieceLength int64, regions []Region) map[int]readerTier {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ret := make(map[int]readerTier)
+	fileStart := r.file.Offset()
+	fileEnd := fileStart + r.file.Length()
+	set := func(from, to int64, tier readerTier) {
+		if to > fileEnd {
+			to = fileEnd
+		}
+		if from >= to {
+			return
+		}
+		for i := int(from / pieceLength); i <= int((to-1)/pieceLength); i++ {
+			if tier > ret[i] {
+				ret[i] = tier
+			}
+		}
+	}
+
+	start := fileStart + r.pos
+	set(start, start+1, tierNow)
+	set(start, (start/pieceLength+1+nextPieces)*pieceLength, tierNext)
+	set(start, start+r.readahead, tierReadahead)
+	set(start+r.readahead, start+r.readahead*2, tierBackground)
+	for _, reg := range regions {
+		set(fileStart+reg.Offset, fileStart+reg.Offset+reg.Length, tierIndex)
+	}
+	return ret
+}
+
+func setPiecePriority(p *torrent.Piece, tier readerTier) {
+	switch tier {
+	case tierNow:
+		p.SetPriority(torrent.PiecePriorityNow)
+	case tierNext:
+		p.SetPriority(torrent.PiecePriorityNext)
+	case tierReadahead:
+		p.SetPriority(torrent.PiecePriorityReadahead)
+	case tierIndex:
+		p.SetPriority(torrent.PiecePriorityHigh)
+	case tierBackground:
+		p.SetPriority(torrent.PiecePriorityNormal)
+	default:
+		p.SetPriority(torrent.PiecePriorityNone)
+	}
+}
+
+// hasIndex reports whether file is container, that can keep index away from file start
+func hasIndex(file *torrent.File) bool {
+	switch strings.ToLower(filepath.Ext(file.Path())) {
+	case ".mkv", ".webm", ".mp4", ".m4v", ".mov", ".avi":
+		return true
+	}
+	return false
+}
+
+func (t *Torrent) SetIndexRegions(file *torrent.File, regions []Region) {
+	t.muPriority.Lock()
+	t.indexRegions[file.Path()] = regions
+	t.muPriority.Unlock()
+	t.schedulePriorities()
+}
+
+func (t *Torrent) IndexRegions(file *torrent.File) []Region {
+	t.muPriority.Lock()
+	defer t.muPriority.Unlock()
+	return t.getIndexRegions(file)
+}
+
+// getIndexRegions returns index regions of file, on first call they are read
+// from container in background, muPriority must be locked
+func (t *Torrent) getIndexRegions(file *torrent.File) []Region {
+	if regions, ok := t.indexRegions[file.Path()]; ok {
+		return regions
+	}
+	t.indexRegions[file.Path()] = nil
+	if hasIndex(file) {
+		go t.findIndexRegions(file)
+	}
+	return nil
+}
+
+// findIndexRegions reads container layout of file and sets its index regions
+func (t *Torrent) findIndexRegions(file *torrent.File) {
+	regions, err := t.readIndex(file)
+	if err != nil {
+		fmt.Println("Index regions:", file.Path(), err)
+		return
+	}
+	idx := make([]Region, 0, len(regions))
+	for _, reg := range regions {
+		idx = append(idx, Region{reg.Offset, reg.Length})
+	}
+	t.SetIndexRegions(file, idx)
+}
+
+// readIndex returns regions of container, that player reads before playback
+func (t *Torrent) readIndex(file *torrent.File) ([]container.Region, error) {
+	reader := t.NewReader(file, 1)
+	if reader == nil {
+		return nil, errors.New("torrent closed")
+	}
+	defer t.CloseReader(reader)
+	return container.Index(reader, file.Length())
+}
+
+// schedulePriorities asks to update priorities out of read path,
+// requests made while update works are joined
+func (t *Torrent) schedulePriorities() {
+	select {
+	case t.prioritiesChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (t *Torrent) watchPriorities() {
+	for {
+		select {
+		case <-t.prioritiesChanged:
+			t.updatePriorities()
+		case <-t.closed:
+			return
+		}
+	}
+}
+
+// updatePriorities sets every piece to the highest tier wanted by open readers
+func (t *Torrent) updatePriorities() {
+	t.muPriority.Lock()
+	defer t.muPriority.Unlock()
+
+	readers := t.getReaders()
+
+	t.muTorrent.Lock()
+	defer t.muTorrent.Unlock()
+	if t.Torrent == nil || t.Torrent.Info() == nil {
+		return
+	}
+	pieceLength := t.Torrent.Info().PieceLength
+
+	wanted := make(map[int]readerTier)
+	for _, r := range readers {
+		for p, tier := range r.tiers(pieceLength, t.getIndexRegions(r.file)) {
+			if tier > wanted[p] {
+				wanted[p] = tier
+			}
+		}
+	}
+
+	for p, tier := range wanted {
+		if t.priorities[p] != tier {
+			setPiecePriority(t.Torrent.Piece(p), tier)
+		}
+	}
+	for p := range t.priorities {
+		if _, ok := wanted[p]; !ok {
+			setPiecePriority(t.Torrent.Piece(p), tierNone)
+		}
+	}
+	t.priorities = wanted
+}
+
+// readerPositions returns pieces, that readers of torrent are reading now
+func (bt *BTServer) readerPositions(hash metainfo.Hash) []int {
+	t := bt.GetTorrent(hash)
+	if t == nil {
+		return nil
+	}
+	positions := make([]int, 0)
+	for _, r := range t.getReaders() {
+		r.mu.Lock()
+		if r.lastPiece >= 0 {
+			positions = append(positions, r.lastPiece)
+		}
+		r.mu.Unlock()
+	}
+	return positions
+}
@@ -0,0 +1,40 @@
+package torr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"server/settings"
+	"server/torr/storage"
+)
+
+func snapshotPath() string {
+	return filepath.Join(settings.Path, "cache.snapshot")
+}
+
+// setSnapshot enables cache snapshot of storage or removes old snapshot, if it is disabled
+func (bt *BTServer) setSnapshot(stor storage.Storage) {
+	bt.snapshot = nil
+	sn, ok := stor.(storage.Snapshotter)
+	if !ok || !settings.Get().CacheSnapshot {
+		os.Remove(snapshotPath())
+		return
+	}
+	sn.SetSnapshot(snapshotPath(), bt.recheckPiece)
+	bt.snapshot = sn
+}
+
+// SaveCache writes memory cache of watched torrents to snapshot, it is called before stop
+func (bt *BTServer) SaveCache() {
+	bt.mu.Lock()
+	sn := bt.snapshot
+	bt.mu.Unlock()
+	if sn == nil {
+		return
+	}
+	err := sn.SaveSnapshot()
+	if err != nil {
+		fmt.Println("Error save cache snapshot:", err)
+	}
+}
@@ -5,10 +5,12 @@ import (
 )
 
 type BTState struct {
-	LocalPort int
-	PeerID    string
-	BannedIPs int
-	DHTs      []*dht.Server
+	LocalPort       int
+	PortForward     PortForwardState
+	PeerID          string
+	BannedIPs       int
+	BlocklistRanges int
+	DHTs            []*dht.Server
 
 	Torrents []*Torrent
 }
@@ -19,6 +21,8 @@ type TorrentStats struct {
 
 	TorrentStatus       TorrentStatus
 	TorrentStatusString string
+	FailReason          string
+	Pinned              bool
 
 	LoadedSize  int64
 	TorrentSize int64
@@ -29,6 +33,10 @@ type TorrentStats struct {
 	DownloadSpeed float64
 	UploadSpeed   float64
 
+	DownloadRateLimit int
+	UploadRateLimit   int
+	ConnectionsLimit  int
+
 	TotalPeers       int
 	PendingPeers     int
 	ActivePeers      int
@@ -0,0 +1,132 @@
+package torr
+
+import (
+	"context"
+	"sync"
+
+	"server/torr/storage"
+
+	"github.com/anacrolix/torrent/metainfo"
+	storage2 "github.com/anacrolix/torrent/storage"
+)
+
+// openedStorage keeps storage of opened torrents, web seeds write pieces to it,
+// and throttles writes of every torrent by its download limiter
+type openedStorage struct {
+	storage.Storage
+
+	bt *BTServer
+
+	torrents map[metainfo.Hash]*openedTorrent
+	mu       sync.Mutex
+}
+
+func newOpenedStorage(bt *BTServer, stor storage.Storage) storage.Storage {
+	return &openedStorage{Storage: stor, bt: bt, torrents: make(map[metainfo.Hash]*openedTorrent)}
+}
+
+func (s *openedStorage) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (storage2.TorrentImpl, error) {
+	ti, err := s.Storage.OpenTorrent(info, infoHash)
+	if err != nil {
+		return nil, err
+	}
+	ot := &openedTorrent{TorrentImpl: ti, s: s, hash: infoHash, peerPieces: make(map[int]bool)}
+	s.mu.Lock()
+	s.torrents[infoHash] = ot
+	s.mu.Unlock()
+	return ot, nil
+}
+
+// webSeedPiece returns storage piece of opened torrent for web seed, nil if torrent
+// isn't opened or peers already write the piece, web seed doesn't write over them
+func (s *openedStorage) webSeedPiece(hash metainfo.Hash, p metainfo.Piece) storage2.PieceImpl {
+	s.mu.Lock()
+	ot := s.torrents[hash]
+	s.mu.Unlock()
+	if ot == nil || ot.peerWriting(p.Index()) {
+		return nil
+	}
+	return &throttledPiece{PieceImpl: ot.TorrentImpl.Piece(p), t: ot, index: p.Index()}
+}
+
+type openedTorrent struct {
+	storage2.TorrentImpl
+
+	s    *openedStorage
+	hash metainfo.Hash
+
+	peerPieces map[int]bool // pieces peers started to write
+	mu         sync.Mutex
+}
+
+func (t *openedTorrent) Piece(p metainfo.Piece) storage2.PieceImpl {
+	return &throttledPiece{PieceImpl: t.TorrentImpl.Piece(p), t: t, index: p.Index(), peer: true}
+}
+
+func (t *openedTorrent) setPeerWriting(piece int, writing bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if writing {
+		t.peerPieces[piece] = true
+	} else {
+		delete(t.peerPieces, piece)
+	}
+}
+
+func (t *openedTorrent) peerWriting(piece int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.peerPieces[piece]
+}
+
+func (t *openedTorrent) Close() error {
+	t.s.mu.Lock()
+	if t.s.torrents[t.hash] == t {
+		delete(t.s.torrents, t.hash)
+	}
+	t.s.mu.Unlock()
+	return t.TorrentImpl.Close()
+}
+
+// throttledPiece waits for download limiter of torrent before write.
+// Client writes chunk from read loop of peer connection without client lock,
+// so wait slows down only reading from that peer
+type throttledPiece struct {
+	storage2.PieceImpl
+
+	t     *openedTorrent
+	index int
+	peer  bool // piece is written by client, not by web seed
+}
+
+func (p *throttledPiece) WriteAt(b []byte, off int64) (int, error) {
+	if t := p.t.s.bt.GetTorrent(p.t.hash); t != nil {
+		t.waitDownload(len(b))
+	}
+	if p.peer {
+		p.t.setPeerWriting(p.index, true)
+	}
+	return p.PieceImpl.WriteAt(b, off)
+}
+
+// MarkNotComplete lets web seeds download piece again, after peers data failed hash
+func (p *throttledPiece) MarkNotComplete() error {
+	p.t.setPeerWriting(p.index, false)
+	return p.PieceImpl.MarkNotComplete()
+}
+
+// waitDownload blocks until download limiter allows n bytes, limiter burst is
+// smaller than piece, so long writes of web seeds are waited by parts
+func (t *Torrent) waitDownload(n int) {
+	l := t.download
+	for n > 0 {
+		part := n
+		if part > l.Burst() {
+			part = l.Burst()
+		}
+		if err := l.WaitN(context.Background(), part); err != nil {
+			return
+		}
+		n -= part
+	}
+}
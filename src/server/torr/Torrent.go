@@ -2,7 +2,6 @@ package torr
 
 import (
 	"fmt"
-	"io"
 	"sort"
 	"sync"
 	"time"
@@ -12,7 +11,7 @@ import (
 
 	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
-	"github.com/labstack/gommon/bytes"
+	"golang.org/x/time/rate"
 )
 
 type TorrentStatus int
@@ -29,6 +28,8 @@ func (t TorrentStatus) String() string {
 		return "Torrent working"
 	case TorrentClosed:
 		return "Torrent closed"
+	case TorrentFailed:
+		return "Torrent failed"
 	default:
 		return "Torrent unknown status"
 	}
@@ -40,17 +41,30 @@ const (
 	TorrentPreload
 	TorrentWorking
 	TorrentClosed
+	TorrentFailed
 )
 
 type Torrent struct {
 	*torrent.Torrent
 
-	status TorrentStatus
+	status     TorrentStatus
+	lastStatus TorrentStatus
+	failReason string
+	addTime    time.Time
 
 	readers map[torrent.Reader]struct{}
 
-	muTorrent sync.Mutex
-	muReader  sync.Mutex
+	muTorrent  sync.Mutex
+	muReader   sync.Mutex
+	muPriority sync.Mutex
+	muTrackers sync.Mutex
+	muWebSeeds sync.Mutex
+	muEvents   sync.Mutex
+	muLimits   sync.Mutex
+
+	priorities        map[int]readerTier
+	indexRegions      map[string][]Region
+	prioritiesChanged chan struct{}
 
 	bt *BTServer
 
@@ -65,35 +79,46 @@ type Torrent struct {
 
 	hash metainfo.Hash
 
+	trackers     []*trackerAnnouncer
+	trackerTiers map[int]chan struct{}
+	ownTrackers  [][]string // trackers of torrent without retrackers
+	announceKey  int32
+
+	webSeeds        []*webSeed
+	webSeedsStarted bool
+
+	limits       *Limits
+	limitConns   int
+	arbiterLimit int
+	download     *rate.Limiter // waited by storage writes
+
 	expiredTime time.Time
+	lastAccess  time.Time
+	pinned      bool
 
 	closed <-chan struct{}
 
+	events  chan Event
+	evicted []int
+
 	progressTicker *time.Ticker
 }
 
-func NewTorrent(magnet metainfo.Magnet, bt *BTServer) (*Torrent, error) {
-	switch settings.Get().RetrackersMode {
-	case 1:
-		magnet.Trackers = append(magnet.Trackers, utils.GetDefTrackers()...)
-	case 2:
-		magnet.Trackers = nil
-	case 3:
-		magnet.Trackers = utils.GetDefTrackers()
-	}
-	goTorrent, _, err := bt.client.AddTorrentSpec(&torrent.TorrentSpec{
-		Trackers:    [][]string{magnet.Trackers},
-		DisplayName: magnet.DisplayName,
-		InfoHash:    magnet.InfoHash,
-	})
-
+func NewTorrent(spec *torrent.TorrentSpec, bt *BTServer) (*Torrent, error) {
+	own := spec.Trackers
+	trackers := withRetrackers(own)
+	//Trackers are announced by Torrent, to change them while torrent works
+	clientSpec := *spec
+	clientSpec.Trackers = nil
+	goTorrent, _, err := bt.client.AddTorrentSpec(&clientSpec)
 	if err != nil {
 		return nil, err
 	}
 
 	bt.mu.Lock()
 	defer bt.mu.Unlock()
-	if tor, ok := bt.torrents[magnet.InfoHash]; ok {
+	if tor, ok := bt.torrents[spec.InfoHash]; ok {
+		go tor.AddTrackers(trackers)
 		return tor, nil
 	}
 
@@ -101,23 +126,74 @@ func NewTorrent(magnet metainfo.Magnet, bt *BTServer) (*Torrent, error) {
 	torr.Torrent = goTorrent
 	torr.status = TorrentAdded
 	torr.lastTimeSpeed = time.Now()
+	torr.lastAccess = time.Now()
+	torr.addTime = time.Now()
 	torr.bt = bt
 	torr.readers = make(map[torrent.Reader]struct{})
-	torr.hash = magnet.InfoHash
+	torr.priorities = make(map[int]readerTier)
+	torr.indexRegions = make(map[string][]Region)
+	torr.prioritiesChanged = make(chan struct{}, 1)
+	torr.hash = spec.InfoHash
 	torr.closed = goTorrent.Closed()
+	torr.events = make(chan Event, 64)
+	torr.announceKey = newAnnounceKey()
+	torr.ownTrackers = own
+	torr.download = utils.Limit(0)
 
 	go torr.watch()
+	go torr.watchPriorities()
+	torr.startTrackers(trackers)
+	go torr.loadDB()
 
-	bt.torrents[magnet.InfoHash] = torr
+	bt.torrents[spec.InfoHash] = torr
 	return torr, nil
 }
 
+// WaitInfo waits torrent info for user requests until metadata timeout, torrent fails on timeout
 func (t *Torrent) WaitInfo() bool {
 	if t.Torrent == nil {
 		return false
 	}
+	gotInfo := t.Torrent.GotInfo()
+	select {
+	case <-gotInfo:
+		return true
+	default:
+	}
+	if t.status == TorrentFailed {
+		return false
+	}
+
+	var timeout <-chan time.Time
+	if sec := settings.Get().MetadataTimeout; sec > 0 {
+		timer := time.NewTimer(time.Until(t.addTime.Add(time.Second * time.Duration(sec))))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-gotInfo:
+		return true
+	case <-t.closed:
+		return false
+	case <-timeout:
+		t.setFailed("timeout getting torrent info")
+		return false
+	}
+}
+
+// waitInfoBackground waits torrent info for background work until torrent is closed,
+// it doesn't fail torrent
+func (t *Torrent) waitInfoBackground() bool {
+	t.muTorrent.Lock()
+	if t.Torrent == nil {
+		t.muTorrent.Unlock()
+		return false
+	}
+	gotInfo := t.Torrent.GotInfo()
+	t.muTorrent.Unlock()
+
 	select {
-	case <-t.Torrent.GotInfo():
+	case <-gotInfo:
 		return true
 	case <-t.closed:
 		return false
@@ -131,24 +207,84 @@ func (t *Torrent) GotInfo() bool {
 	t.status = TorrentGettingInfo
 	if t.WaitInfo() {
 		t.status = TorrentWorking
-		t.expiredTime = time.Now().Add(time.Minute * 5)
+		t.setExpired(settings.Get().Expire.InfoTimeout)
 		return true
+	} else if t.status == TorrentFailed {
+		go t.waitRecover()
+		return false
 	} else {
 		t.Close()
 		return false
 	}
 }
 
+func (t *Torrent) setFailed(reason string) {
+	if t.status == TorrentFailed || t.status == TorrentClosed {
+		return
+	}
+	fmt.Println("Torrent failed:", t.hash.HexString(), reason)
+	t.failReason = reason
+	t.status = TorrentFailed
+	t.setExpired(settings.Get().Expire.IdleTimeout)
+}
+
+// waitRecover makes failed torrent working, if info came before torrent expired
+func (t *Torrent) waitRecover() {
+	t.muTorrent.Lock()
+	if t.Torrent == nil {
+		t.muTorrent.Unlock()
+		return
+	}
+	gotInfo := t.Torrent.GotInfo()
+	t.muTorrent.Unlock()
+
+	select {
+	case <-gotInfo:
+		if t.status == TorrentFailed {
+			t.status = TorrentWorking
+			t.failReason = ""
+			t.setExpired(settings.Get().Expire.InfoTimeout)
+		}
+	case <-t.closed:
+	}
+}
+
+// FailReason returns why torrent failed, empty if it didn't
+func (t *Torrent) FailReason() string {
+	if t.status != TorrentFailed {
+		return ""
+	}
+	return t.failReason
+}
+
+// InfoError returns error for requests, that waited torrent info
+func (t *Torrent) InfoError() string {
+	if reason := t.FailReason(); reason != "" {
+		return reason
+	}
+	return "torrent closed befor get info"
+}
+
 func (t *Torrent) watch() {
 	t.progressTicker = time.NewTicker(time.Second)
 	defer t.progressTicker.Stop()
 
+	t.bt.publish(t.newEvent(Event{Type: EventAdded, Status: t.status.String()}))
+	gotInfo := t.Torrent.GotInfo()
+
 	for {
 		select {
 		case <-t.progressTicker.C:
 			go t.progressEvent()
+			t.watchEvents()
+		case <-gotInfo:
+			gotInfo = nil
+			t.bt.publish(t.newEvent(Event{Type: EventInfo}))
+		case ev := <-t.events:
+			t.bt.publish(ev)
 		case <-t.closed:
 			t.Close()
+			t.bt.publish(t.newEvent(Event{Type: EventClosed}))
 			return
 		}
 	}
@@ -178,10 +314,52 @@ func (t *Torrent) progressEvent() {
 	}
 	t.muTorrent.Unlock()
 	t.lastTimeSpeed = time.Now()
+	t.applyLimits()
+}
+
+// speeds returns download and upload speed measured on last progress tick
+func (t *Torrent) speeds() (float64, float64) {
+	t.muTorrent.Lock()
+	defer t.muTorrent.Unlock()
+	return t.DownloadSpeed, t.UploadSpeed
 }
 
 func (t *Torrent) expired() bool {
-	return len(t.readers) == 0 && t.expiredTime.Before(time.Now()) && (t.status == TorrentWorking || t.status == TorrentClosed)
+	if t.readersCount() > 0 || !(t.status == TorrentWorking || t.status == TorrentClosed || t.status == TorrentFailed) {
+		return false
+	}
+	if t.pinned && t.status != TorrentClosed {
+		timeout := settings.Get().Expire.PinnedTimeout
+		return timeout > 0 && time.Since(t.lastAccess) > time.Second*time.Duration(timeout)
+	}
+	return t.expiredTime.Before(time.Now())
+}
+
+func (t *Torrent) readersCount() int {
+	t.muReader.Lock()
+	defer t.muReader.Unlock()
+	return len(t.readers)
+}
+
+// hasInfo reports whether torrent got info
+func (t *Torrent) hasInfo() bool {
+	t.muTorrent.Lock()
+	defer t.muTorrent.Unlock()
+	return t.Torrent != nil && t.Torrent.Info() != nil
+}
+
+func (t *Torrent) setExpired(sec int) {
+	t.expiredTime = time.Now().Add(time.Second * time.Duration(sec))
+	t.lastAccess = time.Now()
+}
+
+func (t *Torrent) SetPinned(pinned bool) {
+	t.pinned = pinned
+	t.lastAccess = time.Now()
+}
+
+func (t *Torrent) Pinned() bool {
+	return t.pinned
 }
 
 func (t *Torrent) Files() []*torrent.File {
@@ -211,16 +389,19 @@ func (t *Torrent) NewReader(file *torrent.File, readahead int64) torrent.Reader
 	t.muReader.Lock()
 
 	if t.status == TorrentClosed {
+		t.muReader.Unlock()
 		return nil
 	}
 
-	defer t.muReader.Unlock()
-	reader := file.NewReader()
 	if readahead <= 0 {
 		readahead = utils.GetReadahead()
 	}
-	reader.SetReadahead(readahead)
+	reader := newReader(t, file, readahead)
 	t.readers[reader] = struct{}{}
+	t.lastAccess = time.Now()
+	t.event(Event{Type: EventReader, Connected: true, Readers: len(t.readers)})
+	t.muReader.Unlock()
+	t.updatePriorities()
 	return reader
 }
 
@@ -228,8 +409,10 @@ func (t *Torrent) CloseReader(reader torrent.Reader) {
 	t.muReader.Lock()
 	reader.Close()
 	delete(t.readers, reader)
-	t.expiredTime = time.Now().Add(time.Second * 5)
+	t.setExpired(settings.Get().Expire.IdleTimeout)
+	t.event(Event{Type: EventReader, Readers: len(t.readers)})
 	t.muReader.Unlock()
+	t.updatePriorities()
 }
 
 func (t *Torrent) Preload(file *torrent.File, size int64) {
@@ -263,48 +446,41 @@ func (t *Torrent) Preload(file *torrent.File, size int64) {
 		}
 	}()
 
-	buff5mb := int64(5 * 1024 * 1024)
-	startPreloadLength := size
-	endPreloadOffset := int64(0)
-	if startPreloadLength > buff5mb {
-		endPreloadOffset = file.Offset() + file.Length() - buff5mb
-	}
-
-	readerPre := t.NewReader(file, startPreloadLength)
+	readerPre := t.NewReader(file, size)
 	if readerPre == nil {
 		return
 	}
 	defer func() {
 		t.CloseReader(readerPre)
-		t.expiredTime = time.Now().Add(time.Minute * 1)
+		t.setExpired(settings.Get().Expire.PreloadTimeout)
 	}()
 
-	if endPreloadOffset > 0 {
-		readerPost := t.NewReader(file, 1)
-		if readerPre == nil {
-			return
-		}
-		readerPost.Seek(endPreloadOffset, io.SeekStart)
-		readerPost.SetReadahead(buff5mb)
-		defer func() {
-			t.CloseReader(readerPost)
-			t.expiredTime = time.Now().Add(time.Minute * 1)
-		}()
-	}
-
 	if size > file.Length() {
 		size = file.Length()
 	}
 
-	t.PreloadSize = size
+	t.setPreloadSize(size)
+	planned := make(chan struct{})
+	go func() {
+		preloadSize := size
+		for _, reg := range t.planPreload(file, size) {
+			preloadSize += reg.Length
+		}
+		t.setPreloadSize(preloadSize)
+		close(planned)
+	}()
+
 	var lastSize int64 = 0
 	errCount := 0
 	for t.status == TorrentPreload {
-		t.expiredTime = time.Now().Add(time.Minute * 1)
+		t.setExpired(settings.Get().Expire.PreloadTimeout)
 		t.PreloadedBytes = t.Torrent.BytesCompleted()
-		fmt.Println("Preload:", file.Torrent().InfoHash().HexString(), bytes.Format(t.PreloadedBytes), "/", bytes.Format(t.PreloadSize), "Speed:", utils.Format(t.DownloadSpeed), "Peers:[", t.Torrent.Stats().ConnectedSeeders, "]", t.Torrent.Stats().ActivePeers, "/", t.Torrent.Stats().TotalPeers)
-		if t.PreloadedBytes >= t.PreloadSize {
-			return
+		if t.PreloadedBytes >= t.preloadSize() {
+			select {
+			case <-planned:
+				return
+			default:
+			}
 		}
 
 		if lastSize == t.PreloadedBytes {
@@ -320,6 +496,19 @@ func (t *Torrent) Preload(file *torrent.File, size int64) {
 	}
 }
 
+// setPreloadSize changes preload size, it grows when planner finds container index
+func (t *Torrent) setPreloadSize(size int64) {
+	t.muTorrent.Lock()
+	t.PreloadSize = size
+	t.muTorrent.Unlock()
+}
+
+func (t *Torrent) preloadSize() int64 {
+	t.muTorrent.Lock()
+	defer t.muTorrent.Unlock()
+	return t.PreloadSize
+}
+
 func (t *Torrent) drop() {
 	t.muTorrent.Lock()
 	if t.Torrent != nil {
@@ -331,6 +520,7 @@ func (t *Torrent) drop() {
 
 func (t *Torrent) Close() {
 	t.status = TorrentClosed
+	t.stopTrackers()
 	t.bt.mu.Lock()
 	defer t.bt.mu.Unlock()
 
@@ -341,7 +531,8 @@ func (t *Torrent) Close() {
 		r.Close()
 	}
 
-	if _, ok := t.bt.torrents[t.hash]; ok {
+	//Torrent with same hash could be added again after restart
+	if t.bt.torrents[t.hash] == t {
 		delete(t.bt.torrents, t.hash)
 	}
 
@@ -358,6 +549,8 @@ func (t *Torrent) Stats() TorrentStats {
 	st.Hash = t.hash.HexString()
 	st.TorrentStatus = t.status
 	st.TorrentStatusString = t.status.String()
+	st.FailReason = t.FailReason()
+	st.Pinned = t.pinned
 
 	if t.Torrent != nil {
 		st.LoadedSize = t.Torrent.BytesCompleted()
@@ -367,6 +560,11 @@ func (t *Torrent) Stats() TorrentStats {
 		st.DownloadSpeed = t.DownloadSpeed
 		st.UploadSpeed = t.UploadSpeed
 
+		limits := t.Limits()
+		st.DownloadRateLimit = limits.DownloadRateLimit
+		st.UploadRateLimit = limits.UploadRateLimit
+		st.ConnectionsLimit = limits.ConnectionsLimit
+
 		tst := t.Torrent.Stats()
 		st.BytesWritten = tst.BytesWritten.Int64()
 		st.BytesWrittenData = tst.BytesWrittenData.Int64()
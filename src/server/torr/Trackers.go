@@ -0,0 +1,499 @@
+package torr
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"server/settings"
+	"server/utils"
+
+	"github.com/anacrolix/torrent"
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/tracker"
+)
+
+const (
+	trackerMinInterval = time.Minute
+	trackerRetry       = time.Minute * 5
+	trackerNumWant     = 200
+	trackerTimeout     = time.Second * 30
+)
+
+type TrackerStatus struct {
+	Url  string
+	Tier int
+
+	Status       string // waiting, announcing, working, error
+	LastError    string
+	LastAnnounce time.Time
+	NextAnnounce time.Time
+
+	Seeders  int
+	Leechers int
+	Peers    int // peers got on last announce
+}
+
+// trackerAnnouncer keeps status of one tracker, the torrent client gets no trackers,
+// so they can be changed while torrent works
+type trackerAnnouncer struct {
+	t       *Torrent
+	status  TrackerStatus
+	started bool
+}
+
+// startTrackers adds trackers and starts announces of new tiers. Every tier announces
+// to one tracker at time by BEP 12, next tracker of tier is used if announce fails
+func (t *Torrent) startTrackers(tiers [][]string) {
+	t.muTrackers.Lock()
+	defer t.muTrackers.Unlock()
+	if t.trackerTiers == nil {
+		t.trackerTiers = make(map[int]chan struct{})
+	}
+	for tier, urls := range tiers {
+		for _, u := range urls {
+			if u == "" || t.findTracker(u) >= 0 {
+				continue
+			}
+			a := &trackerAnnouncer{
+				t:      t,
+				status: TrackerStatus{Url: u, Tier: tier, Status: "waiting"},
+			}
+			t.trackers = append(t.trackers, a)
+			if _, ok := t.trackerTiers[tier]; !ok {
+				stop := make(chan struct{})
+				t.trackerTiers[tier] = stop
+				go t.runTier(tier, stop)
+			}
+		}
+	}
+}
+
+func (t *Torrent) findTracker(u string) int {
+	for i, a := range t.trackers {
+		if a.status.Url == u {
+			return i
+		}
+	}
+	return -1
+}
+
+// AddTrackers adds trackers to working torrent, added tiers go after existing
+func (t *Torrent) AddTrackers(tiers [][]string) {
+	t.muTrackers.Lock()
+	base := 0
+	for _, a := range t.trackers {
+		if a.status.Tier >= base {
+			base = a.status.Tier + 1
+		}
+	}
+	t.muTrackers.Unlock()
+
+	shifted := make([][]string, base, base+len(tiers))
+	t.startTrackers(append(shifted, tiers...))
+}
+
+// RemoveTracker stops announces to tracker and removes it from torrent
+func (t *Torrent) RemoveTracker(u string) bool {
+	t.muTrackers.Lock()
+	defer t.muTrackers.Unlock()
+	i := t.findTracker(u)
+	if i < 0 {
+		return false
+	}
+	tier := t.trackers[i].status.Tier
+	t.trackers = append(t.trackers[:i], t.trackers[i+1:]...)
+	if len(t.tierTrackers(tier)) == 0 {
+		close(t.trackerTiers[tier])
+		delete(t.trackerTiers, tier)
+	}
+	return true
+}
+
+// SetTrackers replaces all trackers of torrent
+func (t *Torrent) SetTrackers(tiers [][]string) {
+	t.muTrackers.Lock()
+	for _, stop := range t.trackerTiers {
+		close(stop)
+	}
+	t.trackers = nil
+	t.trackerTiers = nil
+	t.muTrackers.Unlock()
+	t.startTrackers(tiers)
+}
+
+func (t *Torrent) Trackers() []TrackerStatus {
+	t.muTrackers.Lock()
+	defer t.muTrackers.Unlock()
+	list := make([]TrackerStatus, 0, len(t.trackers))
+	for _, a := range t.trackers {
+		list = append(list, a.status)
+	}
+	return list
+}
+
+// AnnounceList returns trackers of torrent grouped by tiers
+func (t *Torrent) AnnounceList() [][]string {
+	t.muTrackers.Lock()
+	defer t.muTrackers.Unlock()
+	var tiers [][]string
+	for _, a := range t.trackers {
+		for len(tiers) <= a.status.Tier {
+			tiers = append(tiers, nil)
+		}
+		tiers[a.status.Tier] = append(tiers[a.status.Tier], a.status.Url)
+	}
+	ret := make([][]string, 0, len(tiers))
+	for _, tier := range tiers {
+		if len(tier) > 0 {
+			ret = append(ret, tier)
+		}
+	}
+	return ret
+}
+
+// ownAnnounceList returns trackers of torrent without retrackers,
+// own trackers removed by retrackers mode are returned too
+func (t *Torrent) ownAnnounceList() [][]string {
+	list := t.AnnounceList()
+	mode := settings.Get().RetrackersMode
+	if mode == 1 || mode == 3 {
+		list = removeTrackers(list, utils.GetDefTrackers())
+	}
+	if mode == 2 || mode == 3 {
+		t.muTrackers.Lock()
+		own := t.ownTrackers
+		t.muTrackers.Unlock()
+		var urls []string
+		for _, tier := range own {
+			urls = append(urls, tier...)
+		}
+		list = append(append([][]string{}, own...), removeTrackers(list, urls)...)
+	}
+	return list
+}
+
+// Metainfo returns metainfo of torrent with its current trackers, retrackers aren't included
+func (t *Torrent) Metainfo() metainfo.MetaInfo {
+	if t.Torrent == nil {
+		return metainfo.MetaInfo{}
+	}
+	mi := t.Torrent.Metainfo()
+	mi.AnnounceList = t.ownAnnounceList()
+	mi.Announce = ""
+	if len(mi.AnnounceList) > 0 {
+		mi.Announce = mi.AnnounceList[0][0]
+	}
+	return mi
+}
+
+// withRetrackers returns trackers of torrent changed by retrackers mode
+func withRetrackers(trackers [][]string) [][]string {
+	switch settings.Get().RetrackersMode {
+	case 1:
+		return append(append([][]string{}, trackers...), utils.GetDefTrackers())
+	case 2:
+		return nil
+	case 3:
+		return [][]string{utils.GetDefTrackers()}
+	}
+	return trackers
+}
+
+// removeTrackers returns tiers without trackers of list, empty tiers are removed
+func removeTrackers(tiers [][]string, list []string) [][]string {
+	remove := make(map[string]bool, len(list))
+	for _, u := range list {
+		remove[u] = true
+	}
+	ret := make([][]string, 0, len(tiers))
+	for _, tier := range tiers {
+		kept := make([]string, 0, len(tier))
+		for _, u := range tier {
+			if !remove[u] {
+				kept = append(kept, u)
+			}
+		}
+		if len(kept) > 0 {
+			ret = append(ret, kept)
+		}
+	}
+	return ret
+}
+
+// applyRetrackers rebuilds trackers of working torrent after retrackers settings changed,
+// own trackers are taken from current list, if old mode kept them
+func (t *Torrent) applyRetrackers(oldMode int, oldRetrackers []string) {
+	t.muTrackers.Lock()
+	own := t.ownTrackers
+	t.muTrackers.Unlock()
+	if oldMode == 0 || oldMode == 1 {
+		own = removeTrackers(t.AnnounceList(), oldRetrackers)
+	}
+	t.muTrackers.Lock()
+	t.ownTrackers = own
+	t.muTrackers.Unlock()
+	t.SetTrackers(withRetrackers(own))
+}
+
+// setOwnTrackers replaces trackers of torrent by saved ones with retrackers of settings
+func (t *Torrent) setOwnTrackers(own [][]string) {
+	t.muTrackers.Lock()
+	t.ownTrackers = own
+	t.muTrackers.Unlock()
+	t.SetTrackers(withRetrackers(own))
+}
+
+// SaveTrackers stores trackers with torrent without retrackers, if torrent saved in db
+func (t *Torrent) SaveTrackers() {
+	err := settings.SetTrackers(t.hash.HexString(), t.ownAnnounceList())
+	if err != nil && torrentSaved(t.hash) {
+		fmt.Println("Error save trackers:", t.hash.HexString(), err)
+	}
+}
+
+func torrentSaved(hash metainfo.Hash) bool {
+	torrDb, _ := settings.LoadTorrentDB(hash.HexString())
+	return torrDb != nil
+}
+
+func (a *trackerAnnouncer) set(fn func(st *TrackerStatus)) {
+	a.t.muTrackers.Lock()
+	fn(&a.status)
+	a.t.muTrackers.Unlock()
+}
+
+// tierTrackers returns trackers of tier in announce order, muTrackers must be locked
+func (t *Torrent) tierTrackers(tier int) []*trackerAnnouncer {
+	var list []*trackerAnnouncer
+	for _, a := range t.trackers {
+		if a.status.Tier == tier {
+			list = append(list, a)
+		}
+	}
+	return list
+}
+
+// promoteTracker moves tracker, that answered, to the front of its tier
+func (t *Torrent) promoteTracker(a *trackerAnnouncer) {
+	t.muTrackers.Lock()
+	defer t.muTrackers.Unlock()
+	i := t.findTracker(a.status.Url)
+	if i < 0 || t.trackers[i] != a {
+		return
+	}
+	for j := 0; j < i; j++ {
+		if t.trackers[j].status.Tier == a.status.Tier {
+			copy(t.trackers[j+1:i+1], t.trackers[j:i])
+			t.trackers[j] = a
+			return
+		}
+	}
+}
+
+// runTier announces to trackers of tier until tier is removed or torrent closed
+func (t *Torrent) runTier(tier int, stop chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+		case <-t.closed:
+		}
+		cancel()
+	}()
+	for {
+		t.muTrackers.Lock()
+		list := t.tierTrackers(tier)
+		t.muTrackers.Unlock()
+
+		var interval time.Duration
+		var active *trackerAnnouncer
+		for _, a := range list {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if interval = a.announce(ctx); interval > 0 {
+				active = a
+				t.promoteTracker(a)
+				break
+			}
+		}
+		if active == nil {
+			interval = trackerRetry
+		}
+		next := time.Now().Add(interval)
+		for _, a := range list {
+			a.set(func(st *TrackerStatus) {
+				if a == active || active == nil {
+					st.NextAnnounce = next
+				} else {
+					st.NextAnnounce = time.Time{}
+				}
+			})
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-stop:
+			return
+		case <-t.closed:
+			return
+		}
+	}
+}
+
+// announce returns interval of next announce, 0 if announce failed.
+// Tracker gets started event on first announce, also if it replaced failed tracker of tier
+func (a *trackerAnnouncer) announce(ctx context.Context) time.Duration {
+	event := tracker.None
+	a.set(func(st *TrackerStatus) {
+		if !a.started {
+			event = tracker.Started
+		}
+	})
+	req, ok := a.t.announceRequest(event)
+	if !ok {
+		return 0
+	}
+	a.set(func(st *TrackerStatus) { st.Status = "announcing" })
+
+	bt := a.t.bt
+	res, err := doAnnounce(ctx, a.status.Url, req)
+	if ctx.Err() != nil {
+		return 0
+	}
+	if err != nil {
+		a.set(func(st *TrackerStatus) {
+			st.Status = "error"
+			st.LastError = err.Error()
+			st.LastAnnounce = time.Now()
+		})
+		return 0
+	}
+
+	peers := make([]torrent.Peer, 0, len(res.Peers))
+	for _, p := range res.Peers {
+		peers = append(peers, torrent.Peer{IP: p.IP, Port: p.Port})
+	}
+	a.t.muTorrent.Lock()
+	if a.t.Torrent != nil && bt.client != nil {
+		a.t.Torrent.AddPeers(peers)
+	}
+	a.t.muTorrent.Unlock()
+
+	a.set(func(st *TrackerStatus) {
+		a.started = true
+		st.Status = "working"
+		st.LastError = ""
+		st.LastAnnounce = time.Now()
+		st.Seeders = int(res.Seeders)
+		st.Leechers = int(res.Leechers)
+		st.Peers = len(res.Peers)
+	})
+
+	interval := time.Second * time.Duration(res.Interval)
+	if interval < trackerMinInterval {
+		interval = trackerMinInterval
+	}
+	return interval
+}
+
+// stopTrackers sends stopped event to trackers, that got started event, it doesn't wait answers
+func (t *Torrent) stopTrackers() {
+	t.muTrackers.Lock()
+	var urls []string
+	for _, a := range t.trackers {
+		if a.started {
+			a.started = false
+			urls = append(urls, a.status.Url)
+		}
+	}
+	t.muTrackers.Unlock()
+	if len(urls) == 0 {
+		return
+	}
+	req, ok := t.announceRequest(tracker.Stopped)
+	if !ok {
+		return
+	}
+	for _, u := range urls {
+		go func(u string) {
+			ctx, cancel := context.WithTimeout(context.Background(), trackerTimeout)
+			defer cancel()
+			doAnnounce(ctx, u, req)
+		}(u)
+	}
+}
+
+// doAnnounce sends announce to tracker, it returns when context is done,
+// udp announce is left to finish by its own timeouts
+func doAnnounce(ctx context.Context, u string, req tracker.AnnounceRequest) (tracker.AnnounceResponse, error) {
+	network := "udp4"
+	if settings.Get().EnableIPv6 {
+		network = "udp"
+	}
+	var res tracker.AnnounceResponse
+	var err error
+	done := make(chan struct{})
+	go func() {
+		res, err = tracker.Announce{
+			TrackerUrl: u,
+			Request:    req,
+			UserAgent:  GetPeerProfile(settings.Get()).UserAgent,
+			HttpClient: &http.Client{Timeout: trackerTimeout, Transport: contextTransport{ctx}},
+			UdpNetwork: network,
+		}.Do()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return res, err
+	case <-ctx.Done():
+		return res, ctx.Err()
+	}
+}
+
+// contextTransport sends http announces with context, to cancel them on stop
+type contextTransport struct {
+	ctx context.Context
+}
+
+func (c contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return http.DefaultTransport.RoundTrip(req.WithContext(c.ctx))
+}
+
+func (t *Torrent) announceRequest(event tracker.AnnounceEvent) (tracker.AnnounceRequest, bool) {
+	t.bt.mu.Lock()
+	client := t.bt.client
+	t.bt.mu.Unlock()
+	if client == nil {
+		return tracker.AnnounceRequest{}, false
+	}
+
+	req := tracker.AnnounceRequest{
+		InfoHash:   t.hash,
+		PeerId:     client.PeerID(),
+		Event:      event,
+		Key:        t.announceKey,
+		NumWant:    trackerNumWant,
+		Port:       uint16(client.LocalPort()),
+		Left:       -1,
+		Downloaded: t.BytesReadUsefulData,
+		Uploaded:   t.BytesWrittenData,
+	}
+	t.muTorrent.Lock()
+	if t.Torrent != nil && t.Torrent.Info() != nil {
+		req.Left = t.Torrent.BytesMissing()
+	}
+	t.muTorrent.Unlock()
+	return req, true
+}
+
+func newAnnounceKey() int32 {
+	return rand.Int31()
+}
@@ -0,0 +1,377 @@
+package torr
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/settings"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	webSeedRetry   = time.Second * 30
+	webSeedTimeout = time.Second * 60
+)
+
+type WebSeedStatus struct {
+	Url      string
+	HttpSeed bool
+
+	Status     string // idle, downloading, error, disabled
+	LastError  string
+	Downloaded int64
+	Pieces     int
+}
+
+type webSeed struct {
+	status   WebSeedStatus
+	busy     bool
+	retry    time.Time
+	httpSeed bool
+	disabled bool
+}
+
+var webSeedClient = &http.Client{Timeout: webSeedTimeout}
+
+var errWebSeedNoRange = errors.New("server doesn't support range requests")
+
+// AddWebSeeds adds BEP 19 web seeds and BEP 17 http seeds to torrent,
+// they download pieces wanted by readers
+func (t *Torrent) AddWebSeeds(seeds []settings.WebSeed) {
+	if len(seeds) == 0 {
+		return
+	}
+	t.muWebSeeds.Lock()
+	defer t.muWebSeeds.Unlock()
+	added := false
+	for _, s := range seeds {
+		if s.Url == "" || t.findWebSeed(s.Url) >= 0 {
+			continue
+		}
+		t.webSeeds = append(t.webSeeds, &webSeed{
+			status:   WebSeedStatus{Url: s.Url, HttpSeed: s.HttpSeed, Status: "idle"},
+			httpSeed: s.HttpSeed,
+		})
+		added = true
+	}
+	if added && !t.webSeedsStarted {
+		t.webSeedsStarted = true
+		go t.watchWebSeeds()
+	}
+}
+
+func (t *Torrent) findWebSeed(u string) int {
+	for i, s := range t.webSeeds {
+		if s.status.Url == u {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *Torrent) WebSeeds() []settings.WebSeed {
+	t.muWebSeeds.Lock()
+	defer t.muWebSeeds.Unlock()
+	list := make([]settings.WebSeed, 0, len(t.webSeeds))
+	for _, s := range t.webSeeds {
+		list = append(list, settings.WebSeed{Url: s.status.Url, HttpSeed: s.httpSeed})
+	}
+	return list
+}
+
+func (t *Torrent) WebSeedsStatus() []WebSeedStatus {
+	t.muWebSeeds.Lock()
+	defer t.muWebSeeds.Unlock()
+	list := make([]WebSeedStatus, 0, len(t.webSeeds))
+	for _, s := range t.webSeeds {
+		list = append(list, s.status)
+	}
+	return list
+}
+
+func (t *Torrent) watchWebSeeds() {
+	if !t.waitInfoBackground() {
+		//Seeds are started again by next added seed
+		t.muWebSeeds.Lock()
+		t.webSeedsStarted = false
+		t.muWebSeeds.Unlock()
+		return
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	inFlight := make(map[int]bool)
+	done := make(chan int)
+	for {
+		select {
+		case <-ticker.C:
+		case piece := <-done:
+			delete(inFlight, piece)
+		case <-t.closed:
+			return
+		}
+
+		//Torrent over its download limit doesn't start new pieces
+		if limit := t.downloadLimit(); limit > 0 {
+			if down, _ := t.speeds(); down > float64(limit) {
+				continue
+			}
+		}
+		for _, piece := range t.webSeedPieces(inFlight) {
+			seed := t.freeWebSeed()
+			if seed == nil {
+				break
+			}
+			inFlight[piece] = true
+			go func(seed *webSeed, piece int) {
+				t.fetchWebSeedPiece(seed, piece)
+				select {
+				case done <- piece:
+				case <-t.closed:
+				}
+			}(seed, piece)
+		}
+	}
+}
+
+// webSeedPieces returns incomplete pieces in readers windows, most wanted first
+func (t *Torrent) webSeedPieces(inFlight map[int]bool) []int {
+	t.muPriority.Lock()
+	pieces := make([]int, 0, len(t.priorities))
+	tiers := make(map[int]readerTier, len(t.priorities))
+	for p, tier := range t.priorities {
+		if !inFlight[p] {
+			pieces = append(pieces, p)
+			tiers[p] = tier
+		}
+	}
+	t.muPriority.Unlock()
+
+	sort.Slice(pieces, func(i, j int) bool {
+		if tiers[pieces[i]] != tiers[pieces[j]] {
+			return tiers[pieces[i]] > tiers[pieces[j]]
+		}
+		return pieces[i] < pieces[j]
+	})
+
+	t.muTorrent.Lock()
+	defer t.muTorrent.Unlock()
+	if t.Torrent == nil {
+		return nil
+	}
+	ret := pieces[:0]
+	for _, p := range pieces {
+		//Pieces partly written by peers are left to them
+		if ps := t.Torrent.PieceState(p); !ps.Complete && !ps.Partial {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+func (t *Torrent) freeWebSeed() *webSeed {
+	t.muWebSeeds.Lock()
+	defer t.muWebSeeds.Unlock()
+	for _, s := range t.webSeeds {
+		if !s.busy && !s.disabled && time.Now().After(s.retry) {
+			s.busy = true
+			s.status.Status = "downloading"
+			return s
+		}
+	}
+	return nil
+}
+
+func (t *Torrent) fetchWebSeedPiece(seed *webSeed, piece int) {
+	written, err := t.downloadWebSeedPiece(seed, piece)
+
+	t.muWebSeeds.Lock()
+	defer t.muWebSeeds.Unlock()
+	seed.busy = false
+	if err == errWebSeedNoRange {
+		fmt.Println("Disable web seed:", seed.status.Url, err)
+		seed.disabled = true
+		seed.status.Status = "disabled"
+		seed.status.LastError = err.Error()
+		return
+	}
+	if err != nil {
+		fmt.Println("Error web seed:", seed.status.Url, err)
+		seed.retry = time.Now().Add(webSeedRetry)
+		seed.status.Status = "error"
+		seed.status.LastError = err.Error()
+		return
+	}
+	seed.status.Status = "idle"
+	seed.status.LastError = ""
+	if written {
+		seed.status.Pieces++
+	}
+}
+
+// downloadWebSeedPiece returns false if piece wasn't written, because peers got it first
+func (t *Torrent) downloadWebSeedPiece(seed *webSeed, piece int) (bool, error) {
+	t.muTorrent.Lock()
+	if t.Torrent == nil || t.Torrent.Info() == nil {
+		t.muTorrent.Unlock()
+		return false, errors.New("torrent closed")
+	}
+	info := t.Torrent.Info()
+	t.muTorrent.Unlock()
+
+	p := info.Piece(piece)
+	var data []byte
+	var err error
+	if seed.httpSeed {
+		data, err = httpSeedPiece(seed.status.Url, t.hash, p)
+	} else {
+		data, err = webSeedPiece(seed.status.Url, info, p)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	hash := sha1.Sum(data)
+	if !bytes.Equal(hash[:], p.Hash().Bytes()) {
+		return false, errors.New("piece " + strconv.Itoa(piece) + " hash mismatch")
+	}
+
+	t.muWebSeeds.Lock()
+	seed.status.Downloaded += int64(len(data))
+	t.muWebSeeds.Unlock()
+
+	ls, ok := t.bt.storage.(*openedStorage)
+	if !ok {
+		return false, errors.New("storage not supported")
+	}
+	pi := ls.webSeedPiece(t.hash, p)
+	if pi == nil {
+		//Peers got piece first or torrent was closed
+		return false, nil
+	}
+	_, err = pi.WriteAt(data, 0)
+	if err != nil {
+		return false, err
+	}
+
+	t.muTorrent.Lock()
+	if t.Torrent != nil {
+		t.Torrent.Piece(piece).VerifyData()
+	}
+	t.muTorrent.Unlock()
+	return true, nil
+}
+
+// webSeedPiece reads piece from files on web server, BEP 19
+func webSeedPiece(base string, info *metainfo.Info, p metainfo.Piece) ([]byte, error) {
+	start := p.Offset()
+	end := start + p.Length()
+	data := make([]byte, 0, p.Length())
+
+	var fileOff int64
+	for _, fi := range info.UpvertedFiles() {
+		fileEnd := fileOff + fi.Length
+		if fileEnd > start && fileOff < end {
+			from := start - fileOff
+			if from < 0 {
+				from = 0
+			}
+			to := end - fileOff
+			if to > fi.Length {
+				to = fi.Length
+			}
+			buf, err := getRange(webSeedFileUrl(base, info, fi), from, to-from)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, buf...)
+		}
+		fileOff = fileEnd
+		if fileOff >= end {
+			break
+		}
+	}
+	if int64(len(data)) != p.Length() {
+		return nil, errors.New("wrong piece length")
+	}
+	return data, nil
+}
+
+func webSeedFileUrl(base string, info *metainfo.Info, fi metainfo.FileInfo) string {
+	if len(info.Files) == 0 {
+		if strings.HasSuffix(base, "/") {
+			return base + url.PathEscape(info.Name)
+		}
+		return base
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	path := []string{url.PathEscape(info.Name)}
+	for _, p := range fi.Path {
+		path = append(path, url.PathEscape(p))
+	}
+	return base + strings.Join(path, "/")
+}
+
+func getRange(u string, off, length int64) ([]byte, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+length-1))
+	req.Header.Set("User-Agent", GetPeerProfile(settings.Get()).UserAgent)
+	resp, err := webSeedClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		//Server ignored range, whole file is sent for every piece
+		if off > 0 {
+			return nil, errWebSeedNoRange
+		}
+	default:
+		return nil, errors.New(resp.Status)
+	}
+	buf := make([]byte, length)
+	_, err = io.ReadFull(resp.Body, buf)
+	return buf, err
+}
+
+// httpSeedPiece reads piece from http seed, BEP 17
+func httpSeedPiece(base string, hash metainfo.Hash, p metainfo.Piece) ([]byte, error) {
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	u := base + sep + "info_hash=" + url.QueryEscape(string(hash.Bytes())) + "&piece=" + strconv.Itoa(p.Index())
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", GetPeerProfile(settings.Get()).UserAgent)
+	resp, err := webSeedClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	buf := make([]byte, p.Length())
+	_, err = io.ReadFull(resp.Body, buf)
+	return buf, err
+}
@@ -0,0 +1,33 @@
+package container
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// aviIndex walks RIFF chunks and returns header list and idx1 index
+func aviIndex(r io.ReadSeeker, size int64) ([]Region, error) {
+	var regions []Region
+	header := make([]byte, 12)
+	var off int64 = 12
+	for i := 0; i < maxBoxes && off+8 <= size; i++ {
+		n, err := readAt(r, header, off)
+		if err != nil && n < 8 {
+			return regions, err
+		}
+		chunkType := string(header[:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(header[4:8])) + 8
+		if chunkSize%2 == 1 {
+			chunkSize++
+		}
+
+		switch {
+		case chunkType == "idx1":
+			regions = append(regions, Region{chunkType, off, chunkSize})
+		case chunkType == "LIST" && n >= 12 && string(header[8:12]) == "hdrl":
+			regions = append(regions, Region{"hdrl", off, chunkSize})
+		}
+		off += chunkSize
+	}
+	return regions, nil
+}
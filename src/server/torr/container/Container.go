@@ -0,0 +1,68 @@
+package container
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Region is a byte range in file, that player reads before playback
+type Region struct {
+	Name   string
+	Offset int64
+	Length int64
+}
+
+const maxRegionSize = int64(32 * 1024 * 1024)
+
+var errUnknown = errors.New("unknown container")
+
+// Index finds header and index regions of media file
+func Index(r io.ReadSeeker, size int64) ([]Region, error) {
+	head := make([]byte, 12)
+	_, err := readAt(r, head, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []Region
+	switch {
+	case bytes.Equal(head[4:8], []byte("ftyp")):
+		regions, err = mp4Index(r, size)
+	case bytes.Equal(head[:4], []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		regions, err = mkvIndex(r, size)
+	case bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("AVI ")):
+		regions, err = aviIndex(r, size)
+	default:
+		return nil, errUnknown
+	}
+	if err != nil {
+		return nil, err
+	}
+	return clamp(regions, size), nil
+}
+
+func clamp(regions []Region, size int64) []Region {
+	ret := make([]Region, 0, len(regions))
+	for _, reg := range regions {
+		if reg.Offset < 0 || reg.Offset >= size || reg.Length <= 0 {
+			continue
+		}
+		if reg.Length > maxRegionSize {
+			reg.Length = maxRegionSize
+		}
+		if reg.Offset+reg.Length > size {
+			reg.Length = size - reg.Offset
+		}
+		ret = append(ret, reg)
+	}
+	return ret
+}
+
+func readAt(r io.ReadSeeker, b []byte, off int64) (int, error) {
+	_, err := r.Seek(off, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	return io.ReadFull(r, b)
+}
@@ -0,0 +1,210 @@
+package container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func concat(parts ...[]byte) []byte {
+	var ret []byte
+	for _, p := range parts {
+		ret = append(ret, p...)
+	}
+	return ret
+}
+
+func mp4Box(typ string, payload []byte) []byte {
+	hdr := make([]byte, 8)
+	binary.BigEndian.PutUint32(hdr, uint32(8+len(payload)))
+	copy(hdr[4:], typ)
+	return concat(hdr, payload)
+}
+
+func aviChunk(typ string, payload []byte) []byte {
+	hdr := make([]byte, 8)
+	copy(hdr, typ)
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(len(payload)))
+	ret := concat(hdr, payload)
+	if len(payload)%2 == 1 {
+		ret = append(ret, 0)
+	}
+	return ret
+}
+
+func aviFile(chunks ...[]byte) []byte {
+	body := concat(chunks...)
+	hdr := make([]byte, 12)
+	copy(hdr, "RIFF")
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(4+len(body)))
+	copy(hdr[8:], "AVI ")
+	return concat(hdr, body)
+}
+
+func mkvId(id uint64) []byte {
+	ret := make([]byte, 0, 4)
+	for shift := uint(24); ; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(ret) > 0 {
+			ret = append(ret, b)
+		}
+		if shift == 0 {
+			return ret
+		}
+	}
+}
+
+// mkvEl builds element with one byte size, payload must be shorter than 127 bytes
+func mkvEl(id uint64, payload []byte) []byte {
+	return concat(mkvId(id), []byte{0x80 | byte(len(payload))}, payload)
+}
+
+func mkvSeek(id uint64, pos int) []byte {
+	return mkvEl(idSeek, concat(
+		mkvEl(idSeekID, mkvId(id)),
+		mkvEl(idSeekPos, []byte{byte(pos >> 8), byte(pos)}),
+	))
+}
+
+var mkvHeader = mkvEl(idEBML, []byte{0x42, 0x86, 0x81, 0x01})
+
+// mkvFile builds file with SeekHead pointing to Cues and Tags, segment has unknown size
+func mkvFile(void bool) ([]byte, []Region) {
+	var pre []byte
+	if void {
+		pre = mkvEl(0xEC, make([]byte, 3))
+	}
+	cluster := mkvEl(0x1F43B675, make([]byte, 20))
+	cues := mkvEl(idCues, make([]byte, 6))
+	tags := mkvEl(idTags, make([]byte, 4))
+
+	headLen := len(mkvEl(idSeekHead, concat(mkvSeek(idCues, 0), mkvSeek(idTags, 0))))
+	cuesPos := len(pre) + headLen + len(cluster)
+	tagsPos := cuesPos + len(cues)
+	head := mkvEl(idSeekHead, concat(mkvSeek(idCues, cuesPos), mkvSeek(idTags, tagsPos)))
+
+	segment := concat(mkvId(idSegment), []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	segData := int64(len(mkvHeader) + len(segment))
+	data := concat(mkvHeader, segment, pre, head, cluster, cues, tags)
+	return data, []Region{
+		{"Cues", segData + int64(cuesPos), int64(len(cues))},
+		{"Tags", segData + int64(tagsPos), int64(len(tags))},
+	}
+}
+
+func TestIndex(t *testing.T) {
+	ftyp := mp4Box("ftyp", []byte("isom\x00\x00\x02\x00"))
+	mdat := mp4Box("mdat", make([]byte, 32))
+	moov := mp4Box("moov", make([]byte, 24))
+
+	//moov with 64 bit size far bigger than file
+	bigMoov := []byte("\x00\x00\x00\x01moov\x00\x00\x01\x00\x00\x00\x00\x00")
+
+	hdrl := aviChunk("LIST", []byte("hdrlavih"))
+	movi := aviChunk("LIST", []byte("movi0123456789"))
+	junk := aviChunk("JUNK", []byte{1, 2, 3})
+	idx1 := aviChunk("idx1", make([]byte, 16))
+
+	mkv, mkvRegions := mkvFile(false)
+	mkvVoid, mkvVoidRegions := mkvFile(true)
+
+	//SeekHead claims 4 GB, parser must not allocate it
+	mkvHugeHead := concat(mkvHeader,
+		mkvId(idSegment), []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+		mkvId(idSeekHead), []byte{0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00},
+		make([]byte, 16),
+	)
+	//SeekHead is larger than rest of file
+	mkvShortHead := concat(mkvHeader,
+		mkvId(idSegment), []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+		mkvId(idSeekHead), []byte{0xC0},
+		make([]byte, 16),
+	)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []Region
+		wantErr bool
+	}{
+		{
+			name: "mp4 moov at end",
+			data: concat(ftyp, mdat, moov),
+			want: []Region{{"moov", int64(len(ftyp) + len(mdat)), int64(len(moov))}},
+		},
+		{
+			name: "mp4 moov at start",
+			data: concat(ftyp, moov, mdat),
+			want: []Region{{"moov", int64(len(ftyp)), int64(len(moov))}},
+		},
+		{
+			name: "mp4 large box clamped to file",
+			data: concat(ftyp, mdat, bigMoov),
+			want: []Region{{"moov", int64(len(ftyp) + len(mdat)), int64(len(bigMoov))}},
+		},
+		{
+			name: "avi header and index",
+			data: aviFile(hdrl, movi, idx1),
+			want: []Region{
+				{"hdrl", 12, int64(len(hdrl))},
+				{"idx1", int64(12 + len(hdrl) + len(movi)), int64(len(idx1))},
+			},
+		},
+		{
+			name: "avi odd chunk padding",
+			data: aviFile(hdrl, junk, idx1),
+			want: []Region{
+				{"hdrl", 12, int64(len(hdrl))},
+				{"idx1", int64(12 + len(hdrl) + len(junk)), int64(len(idx1))},
+			},
+		},
+		{
+			name: "mkv seek head",
+			data: mkv,
+			want: mkvRegions,
+		},
+		{
+			name: "mkv void before seek head",
+			data: mkvVoid,
+			want: mkvVoidRegions,
+		},
+		{
+			name:    "mkv huge seek head",
+			data:    mkvHugeHead,
+			wantErr: true,
+		},
+		{
+			name:    "mkv seek head past end",
+			data:    mkvShortHead,
+			wantErr: true,
+		},
+		{
+			name:    "unknown",
+			data:    []byte("#EXTM3U\n#EXT-X-VERSION:3\n"),
+			wantErr: true,
+		},
+		{
+			name:    "short file",
+			data:    []byte("RIFF"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Index(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package container
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	idEBML     = 0x1A45DFA3
+	idSegment  = 0x18538067
+	idSeekHead = 0x114D9B74
+	idSeek     = 0x4DBB
+	idSeekID   = 0x53AB
+	idSeekPos  = 0x53AC
+	idCues     = 0x1C53BB6B
+	idTags     = 0x1254C367
+	idChapters = 0x1043A770
+
+	sizeUnknown = int64(-1)
+)
+
+var mkvIndexIds = map[uint64]string{
+	idCues:     "Cues",
+	idTags:     "Tags",
+	idChapters: "Chapters",
+}
+
+// mkvIndex reads SeekHead of first segment and returns Cues, Tags and Chapters
+func mkvIndex(r io.ReadSeeker, size int64) ([]Region, error) {
+	id, ebmlSize, hdr, err := readElement(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if id != idEBML || ebmlSize == sizeUnknown {
+		return nil, errors.New("wrong ebml header")
+	}
+
+	segOff := hdr + ebmlSize
+	id, _, hdr, err = readElement(r, segOff)
+	if err != nil {
+		return nil, err
+	}
+	if id != idSegment {
+		return nil, errors.New("segment not found")
+	}
+	segData := segOff + hdr
+
+	//SeekHead is the first element of segment, skip Void if present
+	off := segData
+	var headSize int64
+	for i := 0; i < 4; i++ {
+		id, headSize, hdr, err = readElement(r, off)
+		if err != nil {
+			return nil, err
+		}
+		if id == idSeekHead {
+			break
+		}
+		if headSize == sizeUnknown {
+			return nil, errors.New("seek head not found")
+		}
+		off += hdr + headSize
+	}
+	if id != idSeekHead || headSize == sizeUnknown {
+		return nil, errors.New("seek head not found")
+	}
+	//Size is read from file, corrupted file must not allocate more than it has
+	if headSize > maxRegionSize || off+hdr+headSize > size {
+		return nil, errors.New("wrong seek head size")
+	}
+
+	buf := make([]byte, headSize)
+	_, err = readAt(r, buf, off+hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []Region
+	for _, seek := range parseSeekHead(buf) {
+		name, ok := mkvIndexIds[seek.id]
+		if !ok {
+			continue
+		}
+		pos := segData + seek.pos
+		if pos >= size {
+			continue
+		}
+		_, elSize, elHdr, err := readElement(r, pos)
+		if err != nil || elSize == sizeUnknown {
+			continue
+		}
+		regions = append(regions, Region{name, pos, elHdr + elSize})
+	}
+	return regions, nil
+}
+
+type seekEntry struct {
+	id  uint64
+	pos int64
+}
+
+func parseSeekHead(buf []byte) []seekEntry {
+	var ret []seekEntry
+	for len(buf) > 0 {
+		id, n := readVint(buf, true)
+		if n == 0 {
+			break
+		}
+		size, m := readVint(buf[n:], false)
+		if m == 0 || uint64(len(buf)) < uint64(n+m)+size {
+			break
+		}
+		data := buf[n+m : uint64(n+m)+size]
+		buf = buf[uint64(n+m)+size:]
+		if id != idSeek {
+			continue
+		}
+
+		entry := seekEntry{}
+		for len(data) > 0 {
+			cid, cn := readVint(data, true)
+			if cn == 0 {
+				break
+			}
+			csize, cm := readVint(data[cn:], false)
+			if cm == 0 || uint64(len(data)) < uint64(cn+cm)+csize {
+				break
+			}
+			val := data[cn+cm : uint64(cn+cm)+csize]
+			data = data[uint64(cn+cm)+csize:]
+			switch cid {
+			case idSeekID:
+				entry.id = readUint(val)
+			case idSeekPos:
+				entry.pos = int64(readUint(val))
+			}
+		}
+		ret = append(ret, entry)
+	}
+	return ret
+}
+
+// readElement returns element id, data size and header length at offset
+func readElement(r io.ReadSeeker, off int64) (id uint64, size int64, hdr int64, err error) {
+	buf := make([]byte, 12)
+	n, err := readAt(r, buf, off)
+	if err != nil && n == 0 {
+		return 0, 0, 0, err
+	}
+	buf = buf[:n]
+	id, idLen := readVint(buf, true)
+	if idLen == 0 {
+		return 0, 0, 0, errors.New("wrong element id")
+	}
+	usize, sizeLen := readVint(buf[idLen:], false)
+	if sizeLen == 0 {
+		return 0, 0, 0, errors.New("wrong element size")
+	}
+	size = int64(usize)
+	if usize == (uint64(1)<<uint(7*sizeLen))-1 {
+		size = sizeUnknown
+	}
+	return id, size, int64(idLen + sizeLen), nil
+}
+
+// readVint decodes EBML variable length integer, ids keep the length marker
+func readVint(buf []byte, keepMarker bool) (uint64, int) {
+	if len(buf) == 0 || buf[0] == 0 {
+		return 0, 0
+	}
+	length := 1
+	for mask := byte(0x80); buf[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if len(buf) < length {
+		return 0, 0
+	}
+	val := uint64(buf[0])
+	if !keepMarker {
+		val &= uint64(0xFF >> uint(length))
+	}
+	for i := 1; i < length; i++ {
+		val = val<<8 | uint64(buf[i])
+	}
+	return val, length
+}
+
+func readUint(buf []byte) uint64 {
+	var val uint64
+	for _, b := range buf {
+		val = val<<8 | uint64(b)
+	}
+	return val
+}
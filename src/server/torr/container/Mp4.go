@@ -0,0 +1,43 @@
+package container
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const maxBoxes = 64
+
+// mp4Index walks top level boxes and returns moov and index boxes
+func mp4Index(r io.ReadSeeker, size int64) ([]Region, error) {
+	var regions []Region
+	header := make([]byte, 16)
+	var off int64 = 0
+	for i := 0; i < maxBoxes && off+8 <= size; i++ {
+		n, err := readAt(r, header[:8], off)
+		if err != nil || n < 8 {
+			return regions, err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		switch boxSize {
+		case 0:
+			boxSize = size - off
+		case 1:
+			n, err = readAt(r, header[8:16], off+8)
+			if err != nil || n < 8 {
+				return regions, err
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if boxSize < 8 {
+			break
+		}
+
+		switch boxType {
+		case "moov", "sidx", "mfra":
+			regions = append(regions, Region{boxType, off, boxSize})
+		}
+		off += boxSize
+	}
+	return regions, nil
+}
@@ -1,6 +1,8 @@
 package storage
 
 import (
+	"errors"
+
 	"server/torr/storage/state"
 
 	"github.com/anacrolix/torrent/metainfo"
@@ -13,3 +15,62 @@ type Storage interface {
 	GetStats(hash metainfo.Hash) *state.CacheState
 	CloseHash(hash metainfo.Hash)
 }
+
+// EvictHandler is called when cache removes piece of torrent
+type EvictHandler func(hash metainfo.Hash, piece int)
+
+// EvictNotifier is storage, that reports removed pieces
+type EvictNotifier interface {
+	SetEvictHandler(h EvictHandler)
+}
+
+// PositionsHandler returns pieces, that readers of torrent are reading now
+type PositionsHandler func(hash metainfo.Hash) []int
+
+// PositionsReceiver is storage, that uses readers positions for eviction
+type PositionsReceiver interface {
+	SetPositionsHandler(h PositionsHandler)
+}
+
+// LoadHandler is called when storage loaded piece, that torrent doesn't know
+type LoadHandler func(hash metainfo.Hash, piece int)
+
+// Snapshotter is storage, that keeps pieces in file between restarts
+type Snapshotter interface {
+	SetSnapshot(path string, h LoadHandler)
+	SaveSnapshot() error
+}
+
+// CorruptHandler is called when verifier removes piece, that doesn't match its hash
+type CorruptHandler func(hash metainfo.Hash, piece int)
+
+// Verifier is storage, that rehashes completed pieces in background
+type Verifier interface {
+	StartVerify(h CorruptHandler)
+	StopVerify()
+}
+
+var errStorageClosed = errors.New("storage is closed")
+
+// ClosedPiece is given by closed cache instead of nil piece, client gets errors on use
+type ClosedPiece struct{}
+
+func (ClosedPiece) ReadAt(b []byte, off int64) (int, error) {
+	return 0, errStorageClosed
+}
+
+func (ClosedPiece) WriteAt(b []byte, off int64) (int, error) {
+	return 0, errStorageClosed
+}
+
+func (ClosedPiece) MarkComplete() error {
+	return errStorageClosed
+}
+
+func (ClosedPiece) MarkNotComplete() error {
+	return errStorageClosed
+}
+
+func (ClosedPiece) Completion() storage.Completion {
+	return storage.Completion{}
+}
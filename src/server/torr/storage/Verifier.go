@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+const (
+	verifyInterval = time.Second
+	// VerifyPeriod is time between rehashes of same piece, pieces hashed
+	// by torrent client on complete aren't rehashed until it passed
+	VerifyPeriod = time.Minute * 10
+)
+
+// Verifiable is torrent cache rehashed by PieceVerifier
+type Verifiable interface {
+	Hash() metainfo.Hash
+	// NextUnverified returns completed piece with oldest check before time, -1 if there is no one
+	NextUnverified(before time.Time) (piece int, length int64)
+	// VerifyPiece rehashes piece, it returns false if data doesn't match piece hash
+	VerifyPiece(piece int) bool
+	// RemoveCorrupted removes piece data, torrent requests it again
+	RemoveCorrupted(piece int)
+}
+
+// PieceVerifier rehashes completed pieces of caches in background,
+// limited by bytes per second to not load cpu and disk
+type PieceVerifier struct {
+	caches func() []Verifiable
+	budget int64
+
+	onCorrupt CorruptHandler
+	stop      chan struct{}
+	mu        sync.Mutex
+}
+
+func NewPieceVerifier(budget int64, caches func() []Verifiable) *PieceVerifier {
+	return &PieceVerifier{caches: caches, budget: budget}
+}
+
+func (v *PieceVerifier) Start(h CorruptHandler) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.onCorrupt = h
+	if v.stop != nil {
+		return
+	}
+	v.stop = make(chan struct{})
+	go v.watch(v.stop)
+}
+
+func (v *PieceVerifier) Stop() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.stop != nil {
+		close(v.stop)
+		v.stop = nil
+	}
+}
+
+func (v *PieceVerifier) watch(stop chan struct{}) {
+	ticker := time.NewTicker(verifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			v.verify()
+		}
+	}
+}
+
+// verify rehashes pieces with oldest checks, caches take turns until budget of interval is spent
+func (v *PieceVerifier) verify() {
+	v.mu.Lock()
+	onCorrupt := v.onCorrupt
+	v.mu.Unlock()
+
+	before := time.Now().Add(-VerifyPeriod)
+	caches := v.caches()
+	budget := v.budget
+	for budget > 0 && len(caches) > 0 {
+		left := caches[:0]
+		for _, c := range caches {
+			piece, length := c.NextUnverified(before)
+			if piece < 0 {
+				continue
+			}
+			left = append(left, c)
+			budget -= length
+			if !c.VerifyPiece(piece) {
+				c.RemoveCorrupted(piece)
+				if onCorrupt != nil {
+					onCorrupt(c.Hash(), piece)
+				}
+			}
+			if budget <= 0 {
+				break
+			}
+		}
+		caches = left
+	}
+}
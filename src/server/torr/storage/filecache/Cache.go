@@ -1 +1,176 @@
 package filecache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	tstorage "server/torr/storage"
+	"server/torr/storage/state"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/anacrolix/torrent/storage"
+)
+
+type Cache struct {
+	storage.TorrentImpl
+
+	s *Storage
+
+	path string
+	hash metainfo.Hash
+
+	pieceLength int64
+	pieceCount  int
+
+	muPiece sync.Mutex
+	pieces  map[int]*Piece
+
+	verified  int
+	corrupted int
+}
+
+func NewCache(path string, storage *Storage) *Cache {
+	ret := &Cache{
+		path:   path,
+		pieces: make(map[int]*Piece),
+		s:      storage,
+	}
+
+	return ret
+}
+
+func (c *Cache) Init(info *metainfo.Info, hash metainfo.Hash) error {
+	err := os.MkdirAll(c.path, 0755)
+	if err != nil {
+		return err
+	}
+	c.pieceLength = info.PieceLength
+	c.pieceCount = info.NumPieces()
+	c.hash = hash
+
+	for i := 0; i < c.pieceCount; i++ {
+		c.pieces[i] = &Piece{
+			Id:     i,
+			Length: info.Piece(i).Length(),
+			Hash:   info.Piece(i).Hash().HexString(),
+			cache:  c,
+		}
+	}
+	fmt.Println("Open disk cache for:", info.Name, c.path, c.load(), "pieces")
+	return nil
+}
+
+// load takes complete pieces saved in previous start, they are rehashed by verifier first.
+// Pieces, that weren't complete, are removed, it isn't known which parts were written
+func (c *Cache) load() int {
+	files, err := ioutil.ReadDir(c.path)
+	if err != nil {
+		return 0
+	}
+	loaded := 0
+	for _, fi := range files {
+		id, err := strconv.Atoi(fi.Name())
+		p, ok := c.pieces[id]
+		if err != nil || !ok || fi.Size() != p.Length {
+			os.Remove(filepath.Join(c.path, fi.Name()))
+			continue
+		}
+		p.Size = p.Length
+		p.complete = true
+		p.accessed = fi.ModTime()
+		loaded++
+	}
+	return loaded
+}
+
+func (c *Cache) Piece(m metainfo.Piece) storage.PieceImpl {
+	c.muPiece.Lock()
+	defer c.muPiece.Unlock()
+	if val, ok := c.pieces[m.Index()]; ok {
+		return val
+	}
+	return tstorage.ClosedPiece{}
+}
+
+func (c *Cache) Close() error {
+	c.s.mu.Lock()
+	if ch, ok := c.s.caches[c.hash]; ok && ch == c {
+		delete(c.s.caches, c.hash)
+	}
+	c.s.mu.Unlock()
+	c.close()
+	return nil
+}
+
+// close closes files of pieces, data is kept for next open of torrent
+// and counted to capacity till it is cleaned
+func (c *Cache) close() {
+	fmt.Println("Close disk cache for:", c.hash)
+	c.muPiece.Lock()
+	pieces := c.pieces
+	c.pieces = make(map[int]*Piece)
+	c.muPiece.Unlock()
+	var size int64
+	for _, p := range pieces {
+		p.mu.Lock()
+		p.closeFile()
+		size += p.Size
+		p.mu.Unlock()
+		c.s.files.remove(p)
+	}
+	c.s.setIdle(c.hash, size)
+}
+
+func (c *Cache) GetState() state.CacheState {
+	cState := state.CacheState{}
+	cState.Capacity = c.s.capacity
+	cState.PiecesLength = c.pieceLength
+	cState.PiecesCount = c.pieceCount
+	cState.Hash = c.hash.HexString()
+
+	stats := make(map[int]state.ItemState, 0)
+	c.muPiece.Lock()
+	var fill int64 = 0
+	for _, value := range c.pieces {
+		stat := value.Stat()
+		if stat.BufferSize > 0 {
+			fill += stat.BufferSize
+			stats[stat.Id] = stat
+		}
+	}
+	cState.VerifiedPieces = c.verified
+	cState.CorruptedPieces = c.corrupted
+	c.muPiece.Unlock()
+	cState.Filled = fill
+	cState.Pieces = stats
+	return cState
+}
+
+func (c *Cache) filled() int64 {
+	c.muPiece.Lock()
+	defer c.muPiece.Unlock()
+	var fill int64 = 0
+	for _, p := range c.pieces {
+		p.mu.RLock()
+		fill += p.Size
+		p.mu.RUnlock()
+	}
+	return fill
+}
+
+func (c *Cache) getRemPieces() []*Piece {
+	c.muPiece.Lock()
+	defer c.muPiece.Unlock()
+	pieces := make([]*Piece, 0)
+	for _, p := range c.pieces {
+		//First piece holds container header, keep it while torrent is open
+		if p.Size > 0 && p.complete && p.Id > 0 {
+			pieces = append(pieces, p)
+		}
+	}
+	return pieces
+}
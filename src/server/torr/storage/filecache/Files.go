@@ -0,0 +1,52 @@
+package filecache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// maxOpenFiles limits piece files kept open, to not run out of file descriptors
+const maxOpenFiles = 64
+
+// openFiles keeps pieces with open files, recently used first
+type openFiles struct {
+	list  *list.List
+	elems map[*Piece]*list.Element
+	mu    sync.Mutex
+}
+
+func newOpenFiles() *openFiles {
+	return &openFiles{list: list.New(), elems: make(map[*Piece]*list.Element)}
+}
+
+// use marks piece file as recently used and closes file of least recently used piece over limit,
+// piece must not be locked
+func (o *openFiles) use(p *Piece) {
+	o.mu.Lock()
+	if e, ok := o.elems[p]; ok {
+		o.list.MoveToFront(e)
+		o.mu.Unlock()
+		return
+	}
+	o.elems[p] = o.list.PushFront(p)
+	var old *Piece
+	if o.list.Len() > maxOpenFiles {
+		e := o.list.Back()
+		o.list.Remove(e)
+		old = e.Value.(*Piece)
+		delete(o.elems, old)
+	}
+	o.mu.Unlock()
+	if old != nil {
+		old.dropFile()
+	}
+}
+
+func (o *openFiles) remove(p *Piece) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if e, ok := o.elems[p]; ok {
+		o.list.Remove(e)
+		delete(o.elems, p)
+	}
+}
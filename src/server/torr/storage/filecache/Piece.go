@@ -0,0 +1,185 @@
+package filecache
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"server/torr/storage/state"
+
+	"github.com/anacrolix/torrent/storage"
+)
+
+type Piece struct {
+	storage.PieceImpl
+
+	Id     int
+	Hash   string
+	Length int64
+	Size   int64
+
+	complete bool
+	checked  time.Time
+	accessed time.Time
+	file     *os.File
+
+	mu    sync.RWMutex
+	cache *Cache
+}
+
+// fileName is name of complete piece, it is loaded on next start
+func (p *Piece) fileName() string {
+	return filepath.Join(p.cache.path, strconv.Itoa(p.Id))
+}
+
+// partName is name of piece being written, it is removed on next start
+func (p *Piece) partName() string {
+	return p.fileName() + partSuffix
+}
+
+func (p *Piece) WriteAt(b []byte, off int64) (n int, err error) {
+	p.mu.Lock()
+	if p.Size == 0 {
+		go p.cache.s.cleanPieces()
+	}
+	if err = p.openFile(); err != nil {
+		p.mu.Unlock()
+		return 0, errors.New("Can't open piece file for write: " + err.Error())
+	}
+	n, err = p.file.WriteAt(b, off)
+	if end := off + int64(n); end > p.Size {
+		p.Size = end
+	}
+	p.accessed = time.Now()
+	p.mu.Unlock()
+	p.cache.s.files.use(p)
+	return
+}
+
+func (p *Piece) ReadAt(b []byte, off int64) (n int, err error) {
+	p.mu.Lock()
+	if p.Size == 0 || off >= p.Size {
+		p.mu.Unlock()
+		return 0, io.ErrUnexpectedEOF
+	}
+	size := len(b)
+	if off+int64(size) > p.Size {
+		size = int(p.Size - off)
+	}
+	if err = p.openFile(); err != nil {
+		p.mu.Unlock()
+		return 0, err
+	}
+	n, err = p.file.ReadAt(b[:size], off)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	p.accessed = time.Now()
+	p.mu.Unlock()
+	p.cache.s.files.use(p)
+	return
+}
+
+// openFile opens file of piece and keeps it open, p.mu must be locked
+func (p *Piece) openFile() (err error) {
+	if p.file != nil {
+		return nil
+	}
+	if p.complete {
+		p.file, err = os.Open(p.fileName())
+	} else {
+		p.file, err = os.OpenFile(p.partName(), os.O_RDWR|os.O_CREATE, 0644)
+	}
+	if err != nil {
+		p.file = nil
+	}
+	return err
+}
+
+// closeFile closes file of piece, p.mu must be locked
+func (p *Piece) closeFile() {
+	if p.file != nil {
+		p.file.Close()
+		p.file = nil
+	}
+}
+
+// dropFile closes file of piece, that was open for longest time
+func (p *Piece) dropFile() {
+	p.mu.Lock()
+	p.closeFile()
+	p.mu.Unlock()
+}
+
+func (p *Piece) MarkComplete() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Size == 0 {
+		return errors.New("piece is not complete")
+	}
+	if !p.complete {
+		//File is closed before rename, open file can't be renamed on windows
+		p.closeFile()
+		if err := os.Rename(p.partName(), p.fileName()); err != nil {
+			return err
+		}
+	}
+	p.complete = true
+	//Torrent hashed piece before it was marked complete
+	p.checked = time.Now()
+	return nil
+}
+
+func (p *Piece) MarkNotComplete() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.complete {
+		p.closeFile()
+		p.complete = false
+		//Piece isn't trusted on next start
+		if err := os.Rename(p.fileName(), p.partName()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Piece) Completion() storage.Completion {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return storage.Completion{
+		Complete: p.complete && p.Size > 0,
+		Ok:       true,
+	}
+}
+
+func (p *Piece) Release() {
+	p.mu.Lock()
+	p.closeFile()
+	if p.Size > 0 {
+		os.Remove(p.fileName())
+		os.Remove(p.partName())
+	}
+	p.Size = 0
+	p.complete = false
+	p.checked = time.Time{}
+	p.mu.Unlock()
+	p.cache.s.files.remove(p)
+}
+
+func (p *Piece) Stat() state.ItemState {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	itm := state.ItemState{
+		Id:         p.Id,
+		Hash:       p.Hash,
+		Accessed:   p.accessed,
+		Completed:  p.complete,
+		BufferSize: p.Size,
+	}
+	return itm
+}
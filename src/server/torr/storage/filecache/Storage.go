@@ -1,55 +1,118 @@
 package filecache
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"server/settings"
 	"server/torr/storage"
 	"server/torr/storage/state"
 
-	"github.com/anacrolix/missinggo/filecache"
 	"github.com/anacrolix/torrent/metainfo"
 	storage2 "github.com/anacrolix/torrent/storage"
 )
 
+// partSuffix marks piece files, that weren't complete
+const partSuffix = ".part"
+
 type Storage struct {
 	storage.Storage
 
-	caches   map[metainfo.Hash]*filecache.Cache
+	caches   map[metainfo.Hash]*Cache
+	idle     map[metainfo.Hash]idleCache
 	capacity int64
+	path     string
 	mu       sync.Mutex
+	files    *openFiles
+
+	muRemove sync.Mutex
+	isRemove bool
+
+	onEvict  storage.EvictHandler
+	verifier *storage.PieceVerifier
 }
 
 func NewStorage(capacity int64) storage.Storage {
 	stor := new(Storage)
 	stor.capacity = capacity
-	stor.caches = make(map[metainfo.Hash]*filecache.Cache)
+	stor.path = filepath.Join(settings.Path, "cache")
+	stor.caches = make(map[metainfo.Hash]*Cache)
+	stor.idle = make(map[metainfo.Hash]idleCache)
+	stor.files = newOpenFiles()
+	stor.verifier = storage.NewPieceVerifier(verifyBytes, stor.verifiable)
+	//Pieces of previous start are kept, cache is trimmed if capacity was lowered
+	stor.scanIdle()
+	stor.cleanPieces()
 	return stor
 }
 
+// idleCache is cache of torrent, that isn't opened, its pieces are left on disk
+type idleCache struct {
+	size     int64
+	accessed time.Time
+}
+
+// scanIdle finds caches of torrents saved in previous start
+func (s *Storage) scanIdle() {
+	dirs, err := ioutil.ReadDir(s.path)
+	if err != nil {
+		return
+	}
+	for _, dir := range dirs {
+		var hash metainfo.Hash
+		if !dir.IsDir() || hash.FromHexString(dir.Name()) != nil {
+			continue
+		}
+		files, _ := ioutil.ReadDir(filepath.Join(s.path, dir.Name()))
+		ic := idleCache{accessed: dir.ModTime()}
+		for _, fi := range files {
+			ic.size += fi.Size()
+			if fi.ModTime().After(ic.accessed) {
+				ic.accessed = fi.ModTime()
+			}
+		}
+		s.idle[hash] = ic
+	}
+}
+
+func (s *Storage) setIdle(hash metainfo.Hash, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.caches[hash]; !ok {
+		s.idle[hash] = idleCache{size, time.Now()}
+	}
+}
+
 func (s *Storage) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (storage2.TorrentImpl, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	path := filepath.Join(settings.Path, "cache", infoHash.String())
-	cache, err := filecache.NewCache(path)
+	ch := NewCache(filepath.Join(s.path, infoHash.HexString()), s)
+	err := ch.Init(info, infoHash)
 	if err != nil {
 		return nil, err
 	}
-	cache.SetCapacity(s.capacity)
-	s.caches[infoHash] = cache
-	return storage2.NewResourcePieces(cache.AsResourceProvider()).OpenTorrent(info, infoHash)
+	delete(s.idle, infoHash)
+	s.caches[infoHash] = ch
+	return ch, nil
 }
 
-func (s *Storage) GetStats(hash metainfo.Hash) *state.CacheState {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return nil
+func (s *Storage) SetEvictHandler(h storage.EvictHandler) {
+	s.onEvict = h
 }
 
-func (s *Storage) Clean() {
+func (s *Storage) GetStats(hash metainfo.Hash) *state.CacheState {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if c, ok := s.caches[hash]; ok {
+		st := c.GetState()
+		return &st
+	}
+	return nil
 }
 
 func (s *Storage) CloseHash(hash metainfo.Hash) {
@@ -57,13 +120,98 @@ func (s *Storage) CloseHash(hash metainfo.Hash) {
 		return
 	}
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
+	ch, ok := s.caches[hash]
+	delete(s.caches, hash)
+	s.mu.Unlock()
+	if ok {
+		ch.close()
+	}
 }
 
 func (s *Storage) Close() error {
+	s.verifier.Stop()
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
+	caches := s.caches
+	s.caches = make(map[metainfo.Hash]*Cache)
+	s.mu.Unlock()
+	for _, ch := range caches {
+		ch.close()
+	}
 	return nil
 }
+
+func (s *Storage) filled() int64 {
+	var fill int64 = 0
+	for _, c := range s.caches {
+		fill += c.filled()
+	}
+	for _, ic := range s.idle {
+		fill += ic.size
+	}
+	return fill
+}
+
+// cleanPieces removes least recently used pieces of all torrents until the disk cache fits in capacity
+func (s *Storage) cleanPieces() {
+	if s.isRemove {
+		return
+	}
+	s.muRemove.Lock()
+	if s.isRemove {
+		s.muRemove.Unlock()
+		return
+	}
+	s.isRemove = true
+	defer func() { s.isRemove = false }()
+	s.muRemove.Unlock()
+
+	s.mu.Lock()
+	fill := s.filled()
+	if fill <= s.capacity {
+		s.mu.Unlock()
+		return
+	}
+	idle := make([]metainfo.Hash, 0, len(s.idle))
+	for hash := range s.idle {
+		idle = append(idle, hash)
+	}
+	sort.Slice(idle, func(i, j int) bool {
+		return s.idle[idle[i]].accessed.Before(s.idle[idle[j]].accessed)
+	})
+	pieces := make([]*Piece, 0)
+	for _, c := range s.caches {
+		pieces = append(pieces, c.getRemPieces()...)
+	}
+	s.mu.Unlock()
+
+	//Caches of torrents, that aren't opened, are removed first
+	for _, hash := range idle {
+		if fill <= s.capacity {
+			return
+		}
+		s.mu.Lock()
+		if ic, ok := s.idle[hash]; ok {
+			fmt.Println("Clean disk cache:", hash.HexString())
+			os.RemoveAll(filepath.Join(s.path, hash.HexString()))
+			delete(s.idle, hash)
+			fill -= ic.size
+		}
+		s.mu.Unlock()
+	}
+
+	sort.Slice(pieces, func(i, j int) bool {
+		return pieces[i].accessed.Before(pieces[j].accessed)
+	})
+
+	for _, p := range pieces {
+		if fill <= s.capacity {
+			break
+		}
+		fill -= p.Size
+		fmt.Println("Clean disk cache:", p.Id, "\t", p.accessed.Format("15:04:05.000"), "\t", p.Hash)
+		p.Release()
+		if s.onEvict != nil {
+			s.onEvict(p.cache.hash, p.Id)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package filecache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"server/torr/storage"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// verifyBytes is read per second, to not load disk
+const verifyBytes = 4 * 1024 * 1024
+
+// StartVerify starts background rehashing of completed pieces
+func (s *Storage) StartVerify(h storage.CorruptHandler) {
+	s.verifier.Start(h)
+}
+
+func (s *Storage) StopVerify() {
+	s.verifier.Stop()
+}
+
+func (s *Storage) verifiable() []storage.Verifiable {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	caches := make([]storage.Verifiable, 0, len(s.caches))
+	for _, c := range s.caches {
+		caches = append(caches, c)
+	}
+	return caches
+}
+
+func (c *Cache) Hash() metainfo.Hash {
+	return c.hash
+}
+
+func (c *Cache) NextUnverified(before time.Time) (int, int64) {
+	c.muPiece.Lock()
+	defer c.muPiece.Unlock()
+	var next *Piece
+	for _, p := range c.pieces {
+		p.mu.RLock()
+		if p.complete && p.Size > 0 && p.checked.Before(before) && (next == nil || p.checked.Before(next.checked)) {
+			next = p
+		}
+		p.mu.RUnlock()
+	}
+	if next == nil {
+		return -1, 0
+	}
+	return next.Id, next.Length
+}
+
+func (c *Cache) VerifyPiece(piece int) bool {
+	c.muPiece.Lock()
+	p, ok := c.pieces[piece]
+	c.muPiece.Unlock()
+	if !ok {
+		return true
+	}
+	if !p.verify() {
+		return false
+	}
+	c.muPiece.Lock()
+	c.verified++
+	c.muPiece.Unlock()
+	return true
+}
+
+// RemoveCorrupted removes piece file
+func (c *Cache) RemoveCorrupted(piece int) {
+	fmt.Println("Corrupted piece on disk:", c.hash.HexString(), piece)
+	c.muPiece.Lock()
+	p, ok := c.pieces[piece]
+	if ok {
+		c.corrupted++
+	}
+	c.muPiece.Unlock()
+	if !ok {
+		return
+	}
+	p.Release()
+	if c.s.onEvict != nil {
+		c.s.onEvict(c.hash, piece)
+	}
+}
+
+// verify rehashes piece file, piece is locked for writes while hashing
+func (p *Piece) verify() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.complete || p.Size == 0 {
+		return true
+	}
+	p.checked = time.Now()
+	if p.Size != p.Length {
+		return false
+	}
+	ff, err := os.Open(p.fileName())
+	if err != nil {
+		return false
+	}
+	defer ff.Close()
+	h := sha1.New()
+	if _, err = io.Copy(h, io.LimitReader(ff, p.Length)); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == p.Hash
+}
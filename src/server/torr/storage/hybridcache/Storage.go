@@ -0,0 +1,114 @@
+package hybridcache
+
+import (
+	"server/torr/storage"
+	"server/torr/storage/filecache"
+	"server/torr/storage/memcache"
+	"server/torr/storage/state"
+
+	"github.com/anacrolix/torrent/metainfo"
+	storage2 "github.com/anacrolix/torrent/storage"
+)
+
+// Storage keeps hot pieces in memory and demotes evicted pieces to disk
+type Storage struct {
+	storage.Storage
+
+	mem  storage.Storage
+	disk storage.Storage
+}
+
+func NewStorage(memCapacity, diskCapacity int64, policy string) storage.Storage {
+	stor := new(Storage)
+	stor.mem = memcache.NewStorage(memCapacity, policy)
+	stor.disk = filecache.NewStorage(diskCapacity)
+	return stor
+}
+
+func (s *Storage) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (storage2.TorrentImpl, error) {
+	lower, err := s.disk.OpenTorrent(info, infoHash)
+	if err != nil {
+		return nil, err
+	}
+	ti, err := s.mem.OpenTorrent(info, infoHash)
+	if err != nil {
+		lower.Close()
+		return nil, err
+	}
+	if ch, ok := ti.(*memcache.Cache); ok {
+		ch.SetLower(lower, info)
+	}
+	return ti, nil
+}
+
+// SetEvictHandler reports pieces removed from disk, pieces removed from memory are kept on disk
+func (s *Storage) SetEvictHandler(h storage.EvictHandler) {
+	if n, ok := s.disk.(storage.EvictNotifier); ok {
+		n.SetEvictHandler(h)
+	}
+}
+
+func (s *Storage) SetPositionsHandler(h storage.PositionsHandler) {
+	if r, ok := s.mem.(storage.PositionsReceiver); ok {
+		r.SetPositionsHandler(h)
+	}
+}
+
+func (s *Storage) SetSnapshot(path string, h storage.LoadHandler) {
+	if sn, ok := s.mem.(storage.Snapshotter); ok {
+		sn.SetSnapshot(path, h)
+	}
+}
+
+func (s *Storage) SaveSnapshot() error {
+	if sn, ok := s.mem.(storage.Snapshotter); ok {
+		return sn.SaveSnapshot()
+	}
+	return nil
+}
+
+func (s *Storage) StartVerify(h storage.CorruptHandler) {
+	for _, st := range []storage.Storage{s.mem, s.disk} {
+		if v, ok := st.(storage.Verifier); ok {
+			v.StartVerify(h)
+		}
+	}
+}
+
+func (s *Storage) StopVerify() {
+	for _, st := range []storage.Storage{s.mem, s.disk} {
+		if v, ok := st.(storage.Verifier); ok {
+			v.StopVerify()
+		}
+	}
+}
+
+func (s *Storage) GetStats(hash metainfo.Hash) *state.CacheState {
+	st := s.mem.GetStats(hash)
+	if st == nil {
+		return nil
+	}
+	dst := s.disk.GetStats(hash)
+	if dst != nil {
+		st.DiskCapacity = dst.Capacity
+		st.DiskFilled = dst.Filled
+		st.VerifiedPieces += dst.VerifiedPieces
+		st.CorruptedPieces += dst.CorruptedPieces
+		for id, p := range dst.Pieces {
+			if _, ok := st.Pieces[id]; !ok {
+				st.Pieces[id] = p
+			}
+		}
+	}
+	return st
+}
+
+func (s *Storage) CloseHash(hash metainfo.Hash) {
+	s.mem.CloseHash(hash)
+	s.disk.CloseHash(hash)
+}
+
+func (s *Storage) Close() error {
+	s.mem.Close()
+	return s.disk.Close()
+}
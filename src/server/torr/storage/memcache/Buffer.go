@@ -3,84 +3,154 @@ package memcache
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"server/utils"
 )
 
-type buffer struct {
-	pieceId int
-	buf     []byte
-	used    bool
-}
+const (
+	// chunkSize is allocation unit of pieces buffers, 16 memory pages
+	chunkSize = 64 * 1024
+	// poolIdleTimeout is time without allocations, after free chunks are released
+	poolIdleTimeout = time.Minute
+)
 
+// BufferPool gives pieces memory by chunks, chunks are allocated on demand up to capacity
+// and free chunks are released to OS after pool was idle. Pool never gives chunks over capacity
 type BufferPool struct {
-	buffs map[int]*buffer
-	frees int
-	size  int64
-	mu    sync.Mutex
+	free      [][]byte
+	chunkSize int64
+	maxChunks int
+	used      int
+
+	pieceChunks int
+	slow        int
+
+	lastUse time.Time
+	stop    chan struct{}
+	mu      sync.Mutex
 }
 
-func NewBufferPool(bufferLength int64, capacity int64) *BufferPool {
+func NewBufferPool(pieceLength int64, capacity int64) *BufferPool {
 	bp := new(BufferPool)
-	buffsSize := int(capacity/bufferLength) + 3
-	bp.frees = buffsSize
-	bp.size = bufferLength
+	bp.chunkSize = chunkSize
+	if pieceLength < bp.chunkSize {
+		bp.chunkSize = pieceLength
+	}
+	bp.pieceChunks = int((pieceLength + bp.chunkSize - 1) / bp.chunkSize)
+	bp.maxChunks = bp.chunksCount(capacity)
+	bp.lastUse = time.Now()
+	bp.stop = make(chan struct{})
+	go bp.watchIdle(bp.stop)
 	return bp
 }
 
-func (b *BufferPool) mkBuffs() {
-	if b.buffs != nil {
-		return
-	}
-	b.buffs = make(map[int]*buffer, b.frees)
-	fmt.Println("Create", b.frees, "buffers")
-	for i := 0; i < b.frees; i++ {
-		buf := buffer{
-			-1,
-			make([]byte, b.size),
-			false,
-		}
-		b.buffs[i] = &buf
-	}
+// chunksCount returns chunks fitting in capacity, pools of all caches keep storage capacity
+func (b *BufferPool) chunksCount(capacity int64) int {
+	return int(capacity / b.chunkSize)
 }
 
-func (b *BufferPool) GetBuffer(p *Piece) (buff []byte, index int) {
+// SetCapacity changes count of chunks, free chunks over it are released
+func (b *BufferPool) SetCapacity(capacity int64) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.mkBuffs()
-	for id, buf := range b.buffs {
-		if !buf.used {
-			buf.used = true
-			buf.pieceId = p.Id
-			buff = buf.buf
-			index = id
-			b.frees--
-			//fmt.Printf("Get buffer: %v %v %v %p\n", id, p.Id, b.frees, buff)
-			return
+	b.maxChunks = b.chunksCount(capacity)
+	b.trim(b.maxChunks - b.used)
+}
+
+// GetChunk returns chunk for piece data, nil if capacity is used, refused chunks are counted as slow
+func (b *BufferPool) GetChunk() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastUse = time.Now()
+	if b.used >= b.maxChunks {
+		if b.slow == 0 {
+			fmt.Println("Memory cache is full, chunk refused")
 		}
+		b.slow++
+		return nil
 	}
-	fmt.Println("Create slow buffer")
-	return make([]byte, b.size), -1
+	b.used++
+	if n := len(b.free); n > 0 {
+		chunk := b.free[n-1]
+		b.free = b.free[:n-1]
+		return chunk
+	}
+	return make([]byte, b.chunkSize)
 }
 
-func (b *BufferPool) ReleaseBuffer(index int) {
-	if index == -1 {
-		utils.FreeOSMem()
-		return
-	}
+func (b *BufferPool) ReleaseChunks(chunks [][]byte) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.mkBuffs()
-	if buff, ok := b.buffs[index]; ok {
-		buff.used = false
-		buff.pieceId = -1
-		b.frees++
-		//fmt.Println("Release buffer:", index, b.frees)
-	} else {
+	released := false
+	for _, chunk := range chunks {
+		if chunk == nil {
+			continue
+		}
+		b.used--
+		if b.used+len(b.free) < b.maxChunks {
+			b.free = append(b.free, chunk)
+		} else {
+			released = true
+		}
+	}
+	if released {
 		utils.FreeOSMem()
 	}
 }
 
+// Len returns count of pieces, that can be given before capacity is used
 func (b *BufferPool) Len() int {
-	return b.frees
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return (b.maxChunks - b.used) / b.pieceChunks
+}
+
+// Stat returns bytes of used and free chunks and count of refused chunks
+func (b *BufferPool) Stat() (used, free int64, slow int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int64(b.used) * b.chunkSize, int64(len(b.free)) * b.chunkSize, b.slow
+}
+
+func (b *BufferPool) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stop != nil {
+		close(b.stop)
+		b.stop = nil
+	}
+	b.free = nil
+}
+
+func (b *BufferPool) watchIdle(stop chan struct{}) {
+	ticker := time.NewTicker(poolIdleTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			if time.Since(b.lastUse) > poolIdleTimeout {
+				b.trim(0)
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// trim keeps at most count free chunks
+func (b *BufferPool) trim(count int) {
+	if count < 0 {
+		count = 0
+	}
+	if len(b.free) <= count {
+		return
+	}
+	for i := count; i < len(b.free); i++ {
+		b.free[i] = nil
+	}
+	b.free = b.free[:count]
+	utils.FreeOSMem()
 }
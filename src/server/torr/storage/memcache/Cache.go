@@ -2,8 +2,8 @@ package memcache
 
 import (
 	"fmt"
-	"sort"
 	"sync"
+	"time"
 
 	"server/torr/storage/state"
 	"server/utils"
@@ -12,18 +12,27 @@ import (
 	"github.com/anacrolix/torrent/storage"
 )
 
+// activeTimeout is time after last read, while cache keeps its share of storage budget
+const activeTimeout = 30 * time.Second
+
 type Cache struct {
 	storage.TorrentImpl
 
 	s *Storage
 
-	capacity int64
-	filled   int64
-	hash     metainfo.Hash
+	filled int64
+	hash   metainfo.Hash
 
 	pieceLength int64
 	pieceCount  int
-	piecesBuff  int
+	chunkSize   int64
+	pieceChunks int
+
+	//Storage budget, it is changed by rebalance
+	muCap      sync.Mutex
+	capacity   int64
+	piecesBuff int
+	lastRead   time.Time
 
 	muPiece  sync.Mutex
 	muRemove sync.Mutex
@@ -32,7 +41,12 @@ type Cache struct {
 	pieces     map[int]*Piece
 	bufferPull *BufferPool
 
+	lower storage.TorrentImpl
+
 	prcLoaded int
+
+	verified  int
+	corrupted int
 }
 
 func NewCache(capacity int64, storage *Storage) *Cache {
@@ -48,16 +62,17 @@ func NewCache(capacity int64, storage *Storage) *Cache {
 
 func (c *Cache) Init(info *metainfo.Info, hash metainfo.Hash) {
 	fmt.Println("Create cache for:", info.Name)
+	c.pieceLength = info.PieceLength
 	//Min capacity of 2 pieces length
-	cap := info.PieceLength * 2
-	if c.capacity < cap {
-		c.capacity = cap
+	if c.capacity < c.minCapacity() {
+		c.capacity = c.minCapacity()
 	}
-	c.pieceLength = info.PieceLength
 	c.pieceCount = info.NumPieces()
 	c.piecesBuff = int(c.capacity / c.pieceLength)
 	c.hash = hash
 	c.bufferPull = NewBufferPool(c.pieceLength, c.capacity)
+	c.chunkSize = c.bufferPull.chunkSize
+	c.pieceChunks = c.bufferPull.pieceChunks
 
 	for i := 0; i < c.pieceCount; i++ {
 		c.pieces[i] = &Piece{
@@ -67,6 +82,88 @@ func (c *Cache) Init(info *metainfo.Info, hash metainfo.Hash) {
 			cache:  c,
 		}
 	}
+	go c.loadSnapshot(info)
+}
+
+// SetLower attaches slower storage tier, pieces evicted from memory are demoted to it
+func (c *Cache) SetLower(lower storage.TorrentImpl, info *metainfo.Info) {
+	c.muPiece.Lock()
+	defer c.muPiece.Unlock()
+	c.lower = lower
+	for i, p := range c.pieces {
+		p.lower = lower.Piece(info.Piece(i))
+	}
+}
+
+func (c *Cache) minCapacity() int64 {
+	return c.pieceLength * 2
+}
+
+// setCapacity changes capacity given by storage budget, extra pieces are removed,
+// cache without budget removes all pieces
+func (c *Cache) setCapacity(capacity int64) {
+	if capacity > 0 && capacity < c.minCapacity() {
+		capacity = c.minCapacity()
+	}
+	c.muCap.Lock()
+	pool := c.bufferPull
+	if capacity == c.capacity || pool == nil {
+		c.muCap.Unlock()
+		return
+	}
+	shrink := capacity < c.capacity
+	c.capacity = capacity
+	c.piecesBuff = int(capacity / c.pieceLength)
+	c.muCap.Unlock()
+
+	pool.SetCapacity(capacity)
+	if shrink {
+		go c.cleanPieces()
+	}
+}
+
+// budget returns capacity and count of pieces given by storage
+func (c *Cache) budget() (int64, int) {
+	c.muCap.Lock()
+	defer c.muCap.Unlock()
+	return c.capacity, c.piecesBuff
+}
+
+// pool returns buffer pool, nil after cache closed
+func (c *Cache) pool() *BufferPool {
+	c.muCap.Lock()
+	defer c.muCap.Unlock()
+	return c.bufferPull
+}
+
+func (c *Cache) lastReadTime() time.Time {
+	c.muCap.Lock()
+	defer c.muCap.Unlock()
+	return c.lastRead
+}
+
+// active reports whether cache was read recently
+func (c *Cache) active() bool {
+	return time.Since(c.lastReadTime()) < activeTimeout
+}
+
+// touch marks cache read, storage budget is moved to caches becoming active
+func (c *Cache) touch() {
+	c.muCap.Lock()
+	wasActive := time.Since(c.lastRead) < activeTimeout
+	c.lastRead = time.Now()
+	c.muCap.Unlock()
+	if !wasActive {
+		go c.s.rebalance()
+	}
+}
+
+// readerPositions returns pieces, that readers are reading now
+func (c *Cache) readerPositions() []int {
+	if c.s.positions == nil {
+		return nil
+	}
+	return c.s.positions(c.hash)
 }
 
 func (c *Cache) Piece(m metainfo.Piece) storage.PieceImpl {
@@ -81,36 +178,61 @@ func (c *Cache) Piece(m metainfo.Piece) storage.PieceImpl {
 func (c *Cache) Close() error {
 	c.isRemove = false
 	fmt.Println("Close cache for:", c.hash)
-	if _, ok := c.s.caches[c.hash]; ok {
-		delete(c.s.caches, c.hash)
-	}
+	c.s.removeCache(c)
+	c.muPiece.Lock()
 	c.pieces = nil
-	c.bufferPull = nil
+	lower := c.lower
+	c.lower = nil
+	c.muPiece.Unlock()
+	c.muCap.Lock()
+	if c.bufferPull != nil {
+		c.bufferPull.Close()
+		c.bufferPull = nil
+	}
+	c.muCap.Unlock()
+	go c.s.rebalance()
+	if lower != nil {
+		lower.Close()
+	}
 	utils.FreeOSMemGC()
 	return nil
 }
 
 func (c *Cache) GetState() state.CacheState {
 	cState := state.CacheState{}
-	cState.Capacity = c.capacity
+	cState.Capacity, _ = c.budget()
 	cState.PiecesLength = c.pieceLength
 	cState.PiecesCount = c.pieceCount
 	cState.Hash = c.hash.HexString()
+	cState.Policy = c.s.policy.Name()
 
 	stats := make(map[int]state.ItemState, 0)
 	c.muPiece.Lock()
 	var fill int64 = 0
+	var allocated int64 = 0
 	for _, value := range c.pieces {
 		stat := value.Stat()
 		if stat.BufferSize > 0 {
 			fill += stat.BufferSize
 			stats[stat.Id] = stat
 		}
+		allocated += value.allocated()
 	}
 	c.filled = fill
+	cState.VerifiedPieces = c.verified
+	cState.CorruptedPieces = c.corrupted
 	c.muPiece.Unlock()
 	cState.Filled = c.filled
 	cState.Pieces = stats
+
+	if pool := c.pool(); pool != nil {
+		_, cState.BufferFree, cState.SlowBuffers = pool.Stat()
+	}
+	cState.BufferAllocated = allocated
+	//Part of pieces memory, that doesn't hold data yet
+	if allocated > 0 && allocated > fill {
+		cState.Fragmentation = prc(int(allocated-fill), int(allocated))
+	}
 	return cState
 }
 
@@ -127,12 +249,26 @@ func (c *Cache) cleanPieces() {
 	defer func() { c.isRemove = false }()
 	c.muRemove.Unlock()
 
+	c.s.rebalanceIdle()
+
+	pool := c.pool()
+	if pool == nil {
+		return
+	}
+	capacity, _ := c.budget()
 	remPieces := c.getRemPieces()
-	if len(remPieces) > 0 && (c.capacity < c.filled || c.bufferPull.Len() <= 1) {
-		remCount := int((c.filled - c.capacity) / c.pieceLength)
+	if capacity == 0 {
+		//Cache without budget gives back all memory, first piece too
+		remPieces = c.withFirst(remPieces)
+	}
+	if len(remPieces) > 0 && (capacity < c.filled || pool.Len() <= 1) {
+		remCount := int((c.filled - capacity) / c.pieceLength)
 		if remCount < 1 {
 			remCount = 1
 		}
+		if capacity == 0 {
+			remCount = len(remPieces)
+		}
 		if remCount > len(remPieces) {
 			remCount = len(remPieces)
 		}
@@ -149,18 +285,11 @@ func (c *Cache) getRemPieces() []*Piece {
 	pieces := make([]*Piece, 0)
 	fill := int64(0)
 	loading := 0
-	used := make(map[int]struct{})
-	for _, b := range c.bufferPull.buffs {
-		if b.used {
-			used[b.pieceId] = struct{}{}
-		}
-	}
-	for u := range used {
-		v := c.pieces[u]
+	c.muPiece.Lock()
+	defer c.muPiece.Unlock()
+	for _, v := range c.pieces {
 		if v.Size > 0 {
-			if v.Id > 0 {
-				pieces = append(pieces, v)
-			}
+			pieces = append(pieces, v)
 			fill += v.Size
 			if !v.complete {
 				loading++
@@ -168,20 +297,37 @@ func (c *Cache) getRemPieces() []*Piece {
 		}
 	}
 	c.filled = fill
-	sort.Slice(pieces, func(i, j int) bool {
-		return pieces[i].accessed.Before(pieces[j].accessed)
-	})
+	pieces = c.s.policy.Order(c, pieces)
 
-	c.prcLoaded = prc(c.piecesBuff-loading, c.piecesBuff)
+	if _, buff := c.budget(); buff > 0 {
+		c.prcLoaded = prc(buff-loading, buff)
+	}
 	return pieces
 }
 
+// withFirst adds first piece kept by policy to removed pieces
+func (c *Cache) withFirst(pieces []*Piece) []*Piece {
+	c.muPiece.Lock()
+	defer c.muPiece.Unlock()
+	first, ok := c.pieces[0]
+	if !ok || first.Size == 0 {
+		return pieces
+	}
+	for _, p := range pieces {
+		if p == first {
+			return pieces
+		}
+	}
+	return append(pieces, first)
+}
+
 func (c *Cache) removePiece(piece *Piece) {
+	piece.demote()
 	c.muPiece.Lock()
 	defer c.muPiece.Unlock()
 	piece.Release()
 
-	st := fmt.Sprintf("%v%% %v\t%s\t%s", c.prcLoaded, piece.Id, piece.accessed.Format("15:04:05.000"), piece.Hash)
+	st := fmt.Sprintf("%v%% %v\t%s\t%s", c.prcLoaded, piece.Id, piece.lastAccess().Format("15:04:05.000"), piece.Hash)
 	if c.prcLoaded >= 95 {
 		fmt.Println("Clean memory GC:", st)
 		utils.FreeOSMemGC()
@@ -189,6 +335,9 @@ func (c *Cache) removePiece(piece *Piece) {
 		fmt.Println("Clean memory:", st)
 		utils.FreeOSMem()
 	}
+	if c.s.onEvict != nil {
+		c.s.onEvict(c.hash, piece.Id)
+	}
 }
 
 func prc(val, of int) int {
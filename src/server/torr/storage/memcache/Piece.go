@@ -2,6 +2,7 @@ package memcache
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"sync"
 	"time"
@@ -11,6 +12,11 @@ import (
 	"github.com/anacrolix/torrent/storage"
 )
 
+var (
+	errCacheFull   = errors.New("memory cache is full")
+	errCacheClosed = errors.New("memory cache is closed")
+)
+
 type Piece struct {
 	storage.PieceImpl
 
@@ -21,85 +27,250 @@ type Piece struct {
 
 	complete bool
 	readed   bool
+	checked  time.Time
+	hits     int
 	accessed time.Time
-	buffer   []byte
-	bufIndex int
+	chunks   [][]byte
 
 	mu    sync.RWMutex
 	cache *Cache
+	lower storage.PieceImpl
+
+	//Access bookkeeping is changed by concurrent readers
+	muStat sync.Mutex
 }
 
 func (p *Piece) WriteAt(b []byte, off int64) (n int, err error) {
+	pool := p.cache.pool()
+	if pool == nil {
+		return 0, errCacheClosed
+	}
+	//Pool gives no chunks over capacity, memory is freed before write
+	if pool.Len() < 1 {
+		p.cache.cleanPieces()
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if p.buffer == nil {
+	if p.chunks == nil {
 		go p.cache.cleanPieces()
-		p.buffer, p.bufIndex = p.cache.bufferPull.GetBuffer(p)
-		if p.buffer == nil {
-			return 0, errors.New("Can't get buffer write")
-		}
+		p.chunks = make([][]byte, p.cache.pieceChunks)
+	}
+	if off+int64(len(b)) > p.Length {
+		return 0, errors.New("Write out of piece")
+	}
+	n, err = p.writeChunks(pool, b, off)
+	//Same data can be written twice, by peer and web seed
+	if p.Size += int64(n); p.Size > p.Length {
+		p.Size = p.Length
+	}
+	p.markAccess(false)
+	if err != nil {
+		//Data is wanted by reader, cache left without budget asks storage for it
+		p.cache.touch()
 	}
-	n = copy(p.buffer[off:], b[:])
-	p.Size += int64(n)
-	p.accessed = time.Now()
 	return
 }
 
 func (p *Piece) ReadAt(b []byte, off int64) (n int, err error) {
+	if p.lower != nil && !p.loaded() {
+		p.promote()
+		if !p.loaded() && p.lower.Completion().Complete {
+			return p.lower.ReadAt(b, off)
+		}
+	}
+
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	size := len(b)
-	if size+int(off) > len(p.buffer) {
-		size = len(p.buffer) - int(off)
+	size := int64(len(b))
+	if size+off > p.Length {
+		size = p.Length - off
 		if size < 0 {
 			size = 0
 		}
 	}
-	if len(p.buffer) < int(off) || len(p.buffer) < int(off)+size {
+	if p.chunks == nil || off > p.Length || !p.hasChunks(off, size) {
 		return 0, io.ErrUnexpectedEOF
 	}
-	n = copy(b, p.buffer[int(off) : int(off)+size][:])
-	p.accessed = time.Now()
-	if int(off)+size >= len(p.buffer) {
-		p.readed = true
-	}
+	n = p.readChunks(b[:size], off)
+	p.markAccess(off+size >= p.Length)
+	p.cache.touch()
 	if int64(len(b))+off >= p.Size {
 		go p.cache.cleanPieces()
 	}
 	return n, nil
 }
 
+// writeChunks copies data to chunks from offset, missing chunks are taken from pool,
+// write stops if pool has no chunks left
+func (p *Piece) writeChunks(pool *BufferPool, b []byte, off int64) (n int, err error) {
+	chunkSize := p.cache.chunkSize
+	for n < len(b) {
+		i := (off + int64(n)) / chunkSize
+		if p.chunks[i] == nil {
+			if p.chunks[i] = pool.GetChunk(); p.chunks[i] == nil {
+				return n, errCacheFull
+			}
+		}
+		n += copy(p.chunks[i][(off+int64(n))%chunkSize:], b[n:])
+	}
+	return n, nil
+}
+
+// releaseChunks gives chunks back to pool, must be called with lock
+func (p *Piece) releaseChunks() {
+	if pool := p.cache.pool(); pool != nil && p.chunks != nil {
+		pool.ReleaseChunks(p.chunks)
+	}
+	p.chunks = nil
+}
+
+// readChunks copies data from chunks, chunks must be allocated
+func (p *Piece) readChunks(b []byte, off int64) (n int) {
+	chunkSize := p.cache.chunkSize
+	for n < len(b) {
+		i := (off + int64(n)) / chunkSize
+		n += copy(b[n:], p.chunks[i][(off+int64(n))%chunkSize:])
+	}
+	return
+}
+
+func (p *Piece) hasChunks(off, size int64) bool {
+	if size <= 0 {
+		return true
+	}
+	chunkSize := p.cache.chunkSize
+	for i := off / chunkSize; i <= (off+size-1)/chunkSize; i++ {
+		if p.chunks[i] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// loaded reports whether piece has buffer in memory
+func (p *Piece) loaded() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.chunks != nil
+}
+
+// allocated returns bytes of chunks taken by piece
+func (p *Piece) allocated() int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var size int64
+	for _, c := range p.chunks {
+		size += int64(len(c))
+	}
+	return size
+}
+
 func (p *Piece) MarkComplete() error {
-	if len(p.buffer) == 0 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.chunks) == 0 {
 		return errors.New("piece is not complete")
 	}
 	p.complete = true
+	//Torrent hashed piece before it was marked complete
+	p.checked = time.Now()
 	return nil
 }
 
 func (p *Piece) MarkNotComplete() error {
+	p.mu.Lock()
 	p.complete = false
+	p.mu.Unlock()
+	if p.lower != nil {
+		p.lower.MarkNotComplete()
+	}
 	return nil
 }
 
 func (p *Piece) Completion() storage.Completion {
+	p.mu.RLock()
+	complete := p.complete && len(p.chunks) > 0
+	p.mu.RUnlock()
+	if !complete && p.lower != nil {
+		complete = p.lower.Completion().Complete
+	}
 	return storage.Completion{
-		Complete: p.complete && len(p.buffer) > 0,
+		Complete: complete,
 		Ok:       true,
 	}
 }
 
-func (p *Piece) Release() {
+// demote copies complete piece to lower tier before buffer is released
+func (p *Piece) demote() {
+	if p.lower == nil {
+		return
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if !p.complete || p.chunks == nil || p.Size < p.Length {
+		return
+	}
+	if p.lower.Completion().Complete {
+		return
+	}
+	buf := make([]byte, p.Length)
+	p.readChunks(buf, 0)
+	_, err := p.lower.WriteAt(buf, 0)
+	if err == nil {
+		err = p.lower.MarkComplete()
+	}
+	if err != nil {
+		fmt.Println("Error demote piece:", p.Id, err)
+		p.lower.MarkNotComplete()
+	}
+}
+
+// promote loads piece from lower tier back into memory, piece that can't be read
+// from lower tier is marked not complete there and is downloaded again
+func (p *Piece) promote() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.buffer != nil {
-		p.buffer = nil
-		p.cache.bufferPull.ReleaseBuffer(p.bufIndex)
-		p.bufIndex = -1
+	pool := p.cache.pool()
+	if p.chunks != nil || pool == nil || !p.lower.Completion().Complete {
+		return
 	}
+	go p.cache.cleanPieces()
+	buf := make([]byte, p.Length)
+	n, err := p.lower.ReadAt(buf, 0)
+	if int64(n) < p.Length {
+		//Short read without error is a miss too, piece data is incomplete
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		fmt.Println("Error promote piece:", p.Id, err)
+		p.lower.MarkNotComplete()
+		return
+	}
+	p.chunks = make([][]byte, p.cache.pieceChunks)
+	if _, err = p.writeChunks(pool, buf, 0); err != nil {
+		//Memory is full, piece is read from lower tier
+		p.releaseChunks()
+		return
+	}
+	p.Size = p.Length
+	//Lower piece is rehashed by its own verifier
+	p.checked = time.Now()
+	p.complete = true
+	p.markAccess(false)
+}
+
+func (p *Piece) Release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.releaseChunks()
 	p.Size = 0
+	p.muStat.Lock()
+	p.hits = 0
+	p.muStat.Unlock()
+	p.checked = time.Time{}
 	p.complete = false
 }
 
@@ -107,9 +278,39 @@ func (p *Piece) Stat() state.ItemState {
 	itm := state.ItemState{
 		Id:         p.Id,
 		Hash:       p.Hash,
-		Accessed:   p.accessed,
+		Accessed:   p.lastAccess(),
 		Completed:  p.complete,
 		BufferSize: p.Size,
 	}
 	return itm
 }
+
+// markAccess updates access time, read to piece end counts as piece read
+func (p *Piece) markAccess(readEnd bool) {
+	p.muStat.Lock()
+	defer p.muStat.Unlock()
+	p.accessed = time.Now()
+	if readEnd {
+		p.readed = true
+		p.hits++
+	}
+}
+
+func (p *Piece) lastAccess() time.Time {
+	p.muStat.Lock()
+	defer p.muStat.Unlock()
+	return p.accessed
+}
+
+// hitCount returns how many times piece was read to end
+func (p *Piece) hitCount() int {
+	p.muStat.Lock()
+	defer p.muStat.Unlock()
+	return p.hits
+}
+
+func (p *Piece) wasRead() bool {
+	p.muStat.Lock()
+	defer p.muStat.Unlock()
+	return p.readed
+}
@@ -0,0 +1,140 @@
+package memcache
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	PolicyLRU      = "lru"
+	PolicyPlayhead = "playhead"
+	PolicyARC      = "arc"
+)
+
+// Policy orders pieces, that can be removed from cache, first pieces are removed first
+type Policy interface {
+	Name() string
+	Order(c *Cache, pieces []*Piece) []*Piece
+}
+
+// NewPolicy returns eviction policy by name, lru if name is unknown
+func NewPolicy(name string) Policy {
+	switch name {
+	case PolicyPlayhead:
+		return playheadPolicy{}
+	case PolicyARC:
+		return arcPolicy{}
+	default:
+		return lruPolicy{}
+	}
+}
+
+// lruPolicy removes least recently accessed pieces
+type lruPolicy struct{}
+
+func (lruPolicy) Name() string {
+	return PolicyLRU
+}
+
+func (lruPolicy) Order(c *Cache, pieces []*Piece) []*Piece {
+	ret := evictable(pieces)
+	sortAccessed(ret)
+	return ret
+}
+
+// playheadPolicy removes pieces behind readers first, farthest from reader first,
+// then pieces ahead of readers by lru
+type playheadPolicy struct{}
+
+func (playheadPolicy) Name() string {
+	return PolicyPlayhead
+}
+
+func (playheadPolicy) Order(c *Cache, pieces []*Piece) []*Piece {
+	positions := c.readerPositions()
+	if len(positions) == 0 {
+		return lruPolicy{}.Order(c, pieces)
+	}
+
+	behind := make([]*Piece, 0)
+	ahead := make([]*Piece, 0)
+	distance := make(map[int]int)
+	for _, p := range evictable(pieces) {
+		//Nearest reader at or after piece, piece is behind if no reader needs it
+		dist := -1
+		for _, pos := range positions {
+			if pos <= p.Id {
+				dist = -1
+				break
+			}
+			if dist == -1 || pos-p.Id < dist {
+				dist = pos - p.Id
+			}
+		}
+		if dist > 0 {
+			distance[p.Id] = dist
+			behind = append(behind, p)
+		} else {
+			ahead = append(ahead, p)
+		}
+	}
+	sort.Slice(behind, func(i, j int) bool {
+		return distance[behind[i].Id] > distance[behind[j].Id]
+	})
+	return append(behind, lruPolicy{}.Order(c, ahead)...)
+}
+
+// arcPolicy keeps pieces read more than once, like container index, while they take
+// up to half of cache, pieces read once are removed by lru before them
+type arcPolicy struct{}
+
+func (arcPolicy) Name() string {
+	return PolicyARC
+}
+
+func (arcPolicy) Order(c *Cache, pieces []*Piece) []*Piece {
+	recent := make([]*Piece, 0)
+	frequent := make([]*Piece, 0)
+	for _, p := range evictable(pieces) {
+		if p.hitCount() > 1 {
+			frequent = append(frequent, p)
+		} else {
+			recent = append(recent, p)
+		}
+	}
+	sortAccessed(recent)
+	sortAccessed(frequent)
+
+	ret := make([]*Piece, 0, len(pieces))
+	_, buff := c.budget()
+	protect := buff / 2
+	if len(frequent) > protect {
+		//Oldest frequent pieces over half of cache are removed first
+		ret = append(ret, frequent[:len(frequent)-protect]...)
+		frequent = frequent[len(frequent)-protect:]
+	}
+	ret = append(ret, recent...)
+	return append(ret, frequent...)
+}
+
+// evictable returns pieces, that policies can remove, first piece holds container header
+// and is kept while torrent is open
+func evictable(pieces []*Piece) []*Piece {
+	ret := make([]*Piece, 0, len(pieces))
+	for _, p := range pieces {
+		if p.Id > 0 {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+func sortAccessed(pieces []*Piece) {
+	accessed := make(map[*Piece]time.Time, len(pieces))
+	for _, p := range pieces {
+		accessed[p] = p.lastAccess()
+	}
+	sort.Slice(pieces, func(i, j int) bool {
+		return accessed[pieces[i]].Before(accessed[pieces[j]])
+	})
+}
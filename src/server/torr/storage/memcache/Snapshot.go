@@ -0,0 +1,293 @@
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"server/torr/storage"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// Snapshot file is magic and records of torrent hash, piece index, piece length and piece data
+const snapshotMagic = "TSSNAP1\n"
+
+type snapshotPiece struct {
+	id     int
+	offset int64
+	length int64
+}
+
+type snapshot struct {
+	path   string
+	pieces map[metainfo.Hash][]snapshotPiece
+	onLoad storage.LoadHandler
+	mu     sync.Mutex
+}
+
+// SetSnapshot enables snapshot file, pieces saved in it are loaded in background
+// when torrent is opened and handler is called for every loaded piece
+func (s *Storage) SetSnapshot(path string, h storage.LoadHandler) {
+	sn := &snapshot{path: path, pieces: make(map[metainfo.Hash][]snapshotPiece), onLoad: h}
+	err := sn.readIndex()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println("Error read cache snapshot:", err)
+			os.Remove(path)
+		}
+		sn.pieces = make(map[metainfo.Hash][]snapshotPiece)
+	}
+	s.snapshot = sn
+}
+
+// SaveSnapshot writes completed pieces of watched torrents, recently read torrents first,
+// pieces of torrents not opened since last start are dropped
+func (s *Storage) SaveSnapshot() error {
+	if s.snapshot == nil {
+		return nil
+	}
+	s.mu.Lock()
+	caches := make([]*Cache, 0)
+	for _, c := range s.caches {
+		if c.watched() {
+			caches = append(caches, c)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(caches, func(i, j int) bool {
+		return caches[i].lastReadTime().After(caches[j].lastReadTime())
+	})
+
+	s.snapshot.mu.Lock()
+	defer s.snapshot.mu.Unlock()
+	tmp := s.snapshot.path + ".tmp"
+	ff, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(ff)
+	w.WriteString(snapshotMagic)
+	var size, count int64
+	for _, c := range caches {
+		for _, p := range c.snapshotPieces() {
+			if size+p.Length > s.capacity {
+				break
+			}
+			var written bool
+			written, err = writeSnapshotPiece(w, c.hash, p)
+			if err != nil {
+				break
+			}
+			if written {
+				size += p.Length
+				count++
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	ff.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if count == 0 {
+		os.Remove(tmp)
+		os.Remove(s.snapshot.path)
+		return nil
+	}
+	fmt.Println("Save cache snapshot:", count, "pieces")
+	return os.Rename(tmp, s.snapshot.path)
+}
+
+// writeSnapshotPiece writes complete piece, it returns false if piece was skipped
+func writeSnapshotPiece(w io.Writer, hash metainfo.Hash, p *Piece) (bool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if !p.complete || p.chunks == nil || p.Size < p.Length {
+		return false, nil
+	}
+	buf := make([]byte, p.Length)
+	p.readChunks(buf, 0)
+	hdr := make([]byte, 28)
+	copy(hdr, hash[:])
+	binary.BigEndian.PutUint32(hdr[20:], uint32(p.Id))
+	binary.BigEndian.PutUint32(hdr[24:], uint32(p.Length))
+	_, err := w.Write(hdr)
+	if err == nil {
+		_, err = w.Write(buf)
+	}
+	return err == nil, err
+}
+
+func (sn *snapshot) readIndex() error {
+	ff, err := os.Open(sn.path)
+	if err != nil {
+		return err
+	}
+	defer ff.Close()
+	r := bufio.NewReader(ff)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err = io.ReadFull(r, magic); err != nil || string(magic) != snapshotMagic {
+		return errors.New("wrong snapshot format")
+	}
+	offset := int64(len(snapshotMagic))
+	hdr := make([]byte, 28)
+	for {
+		_, err = io.ReadFull(r, hdr)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var hash metainfo.Hash
+		copy(hash[:], hdr[:20])
+		p := snapshotPiece{
+			id:     int(binary.BigEndian.Uint32(hdr[20:])),
+			offset: offset + 28,
+			length: int64(binary.BigEndian.Uint32(hdr[24:])),
+		}
+		if _, err = r.Discard(int(p.length)); err != nil {
+			return err
+		}
+		sn.pieces[hash] = append(sn.pieces[hash], p)
+		offset = p.offset + p.length
+	}
+}
+
+// take returns saved pieces of torrent and forgets them
+func (sn *snapshot) take(hash metainfo.Hash) []snapshotPiece {
+	sn.mu.Lock()
+	defer sn.mu.Unlock()
+	pieces, ok := sn.pieces[hash]
+	if !ok {
+		return nil
+	}
+	delete(sn.pieces, hash)
+	return pieces
+}
+
+// loadSnapshot fills cache with saved pieces in background, pieces are marked complete
+// when they match piece hashes, file is removed when pieces of all torrents were taken
+func (c *Cache) loadSnapshot(info *metainfo.Info) {
+	sn := c.s.snapshot
+	if sn == nil {
+		return
+	}
+	saved := sn.take(c.hash)
+	if len(saved) == 0 {
+		return
+	}
+	//Lock is taken only for file access, snapshot can be saved while pieces are hashed and copied
+	sn.mu.Lock()
+	ff, err := os.Open(sn.path)
+	sn.mu.Unlock()
+	if err != nil {
+		fmt.Println("Error load cache snapshot:", err)
+		return
+	}
+
+	loaded := 0
+	for _, sp := range saved {
+		c.muPiece.Lock()
+		p, ok := c.pieces[sp.id]
+		c.muPiece.Unlock()
+		_, buff := c.budget()
+		full := loaded >= buff
+		if full {
+			break
+		}
+		if !ok || sp.length != p.Length {
+			continue
+		}
+		buf := make([]byte, sp.length)
+		sn.mu.Lock()
+		_, err := ff.ReadAt(buf, sp.offset)
+		sn.mu.Unlock()
+		if err != nil {
+			fmt.Println("Error load cache snapshot:", err)
+			break
+		}
+		sum := sha1.Sum(buf)
+		if !bytes.Equal(sum[:], info.Piece(sp.id).Hash().Bytes()) {
+			fmt.Println("Wrong piece in cache snapshot:", c.hash.HexString(), sp.id)
+			continue
+		}
+		if !p.load(buf) {
+			continue
+		}
+		loaded++
+		if sn.onLoad != nil {
+			sn.onLoad(c.hash, sp.id)
+		}
+	}
+	ff.Close()
+	fmt.Println("Load cache snapshot:", c.hash.HexString(), loaded, "pieces")
+	sn.mu.Lock()
+	if len(sn.pieces) == 0 {
+		os.Remove(sn.path)
+	}
+	sn.mu.Unlock()
+}
+
+// load puts verified data to piece, if torrent didn't start to write it
+func (p *Piece) load(buf []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pool := p.cache.pool()
+	if p.chunks != nil || pool == nil {
+		return false
+	}
+	p.chunks = make([][]byte, p.cache.pieceChunks)
+	if _, err := p.writeChunks(pool, buf, 0); err != nil {
+		p.releaseChunks()
+		return false
+	}
+	p.Size = p.Length
+	p.complete = true
+	p.checked = time.Now()
+	p.markAccess(false)
+	return true
+}
+
+// watched reports whether torrent was read, not only opened
+func (c *Cache) watched() bool {
+	c.muPiece.Lock()
+	defer c.muPiece.Unlock()
+	for _, p := range c.pieces {
+		if p.wasRead() {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotPieces returns completed pieces, recently accessed first
+func (c *Cache) snapshotPieces() []*Piece {
+	c.muPiece.Lock()
+	defer c.muPiece.Unlock()
+	pieces := make([]*Piece, 0)
+	for _, p := range c.pieces {
+		if p.complete && p.Size >= p.Length {
+			pieces = append(pieces, p)
+		}
+	}
+	sort.Slice(pieces, func(i, j int) bool {
+		return pieces[i].lastAccess().After(pieces[j].lastAccess())
+	})
+	return pieces
+}
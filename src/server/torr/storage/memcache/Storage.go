@@ -1,7 +1,9 @@
 package memcache
 
 import (
+	"sort"
 	"sync"
+	"time"
 
 	"server/torr/storage"
 	"server/torr/storage/state"
@@ -16,12 +18,23 @@ type Storage struct {
 	caches   map[metainfo.Hash]*Cache
 	capacity int64
 	mu       sync.Mutex
+
+	onEvict   storage.EvictHandler
+	positions storage.PositionsHandler
+	policy    Policy
+	snapshot  *snapshot
+	verifier  *storage.PieceVerifier
+
+	activeCaches  int
+	lastRebalance time.Time
 }
 
-func NewStorage(capacity int64) storage.Storage {
+func NewStorage(capacity int64, policy string) storage.Storage {
 	stor := new(Storage)
 	stor.capacity = capacity
+	stor.policy = NewPolicy(policy)
 	stor.caches = make(map[metainfo.Hash]*Cache)
+	stor.verifier = storage.NewPieceVerifier(verifyBytes, stor.verifiable)
 	return stor
 }
 
@@ -29,11 +42,104 @@ func (s *Storage) OpenTorrent(info *metainfo.Info, infoHash metainfo.Hash) (stor
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	ch := NewCache(s.capacity, s)
+	ch.lastRead = time.Now()
 	ch.Init(info, infoHash)
 	s.caches[infoHash] = ch
+	s.rebalanceLocked()
 	return ch, nil
 }
 
+// rebalance shares capacity between caches. Every cache gets minimum, recently read first,
+// caches left without minimum are emptied. Rest is shared by active caches, if there is
+// no active caches all share it
+func (s *Storage) rebalance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rebalanceLocked()
+}
+
+// rebalanceIdle rebalances if some cache became idle since last rebalance
+func (s *Storage) rebalanceIdle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.lastRebalance) < time.Second {
+		return
+	}
+	active := 0
+	for _, c := range s.caches {
+		if c.active() {
+			active++
+		}
+	}
+	if active != s.activeCaches {
+		s.rebalanceLocked()
+	}
+}
+
+func (s *Storage) rebalanceLocked() {
+	s.lastRebalance = time.Now()
+	if len(s.caches) == 0 {
+		return
+	}
+	active := make([]*Cache, 0)
+	idle := make([]*Cache, 0)
+	for _, c := range s.caches {
+		if c.active() {
+			active = append(active, c)
+		} else {
+			idle = append(idle, c)
+		}
+	}
+	s.activeCaches = len(active)
+	if len(active) == 0 {
+		active, idle = idle, nil
+	}
+	sortRead(active)
+	sortRead(idle)
+
+	left := s.capacity
+	given := make(map[*Cache]int64)
+	for _, c := range append(append([]*Cache{}, active...), idle...) {
+		given[c] = 0
+		if left >= c.minCapacity() {
+			given[c] = c.minCapacity()
+			left -= c.minCapacity()
+		}
+	}
+	shared := 0
+	for _, c := range active {
+		if given[c] > 0 {
+			shared++
+		}
+	}
+	if shared > 0 {
+		share := left / int64(shared)
+		for _, c := range active {
+			if given[c] > 0 {
+				given[c] += share
+			}
+		}
+	}
+	for c, capacity := range given {
+		c.setCapacity(capacity)
+	}
+}
+
+// sortRead sorts caches by last read, recently read first
+func sortRead(caches []*Cache) {
+	sort.Slice(caches, func(i, j int) bool {
+		return caches[i].lastReadTime().After(caches[j].lastReadTime())
+	})
+}
+
+func (s *Storage) SetEvictHandler(h storage.EvictHandler) {
+	s.onEvict = h
+}
+
+func (s *Storage) SetPositionsHandler(h storage.PositionsHandler) {
+	s.positions = h
+}
+
 func (s *Storage) GetStats(hash metainfo.Hash) *state.CacheState {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -44,22 +150,33 @@ func (s *Storage) GetStats(hash metainfo.Hash) *state.CacheState {
 	return nil
 }
 
-func (s *Storage) CloseHash(hash metainfo.Hash) {
-	if s.caches == nil {
-		return
-	}
+// removeCache removes closed cache, cache opened again with same hash is kept
+func (s *Storage) removeCache(c *Cache) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if ch, ok := s.caches[hash]; ok {
+	if s.caches[c.hash] == c {
+		delete(s.caches, c.hash)
+	}
+}
+
+func (s *Storage) CloseHash(hash metainfo.Hash) {
+	s.mu.Lock()
+	ch, ok := s.caches[hash]
+	s.mu.Unlock()
+	if ok {
 		ch.Close()
-		delete(s.caches, hash)
 	}
 }
 
 func (s *Storage) Close() error {
+	s.verifier.Stop()
 	s.mu.Lock()
-	defer s.mu.Unlock()
+	caches := make([]*Cache, 0, len(s.caches))
 	for _, ch := range s.caches {
+		caches = append(caches, ch)
+	}
+	s.mu.Unlock()
+	for _, ch := range caches {
 		ch.Close()
 	}
 	return nil
@@ -0,0 +1,110 @@
+package memcache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"server/torr/storage"
+
+	"github.com/anacrolix/torrent/metainfo"
+)
+
+// verifyBytes is hashed per second, to not load cpu
+const verifyBytes = 8 * 1024 * 1024
+
+// StartVerify starts background rehashing of completed pieces
+func (s *Storage) StartVerify(h storage.CorruptHandler) {
+	s.verifier.Start(h)
+}
+
+func (s *Storage) StopVerify() {
+	s.verifier.Stop()
+}
+
+func (s *Storage) verifiable() []storage.Verifiable {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	caches := make([]storage.Verifiable, 0, len(s.caches))
+	for _, c := range s.caches {
+		caches = append(caches, c)
+	}
+	return caches
+}
+
+func (c *Cache) Hash() metainfo.Hash {
+	return c.hash
+}
+
+func (c *Cache) NextUnverified(before time.Time) (int, int64) {
+	c.muPiece.Lock()
+	defer c.muPiece.Unlock()
+	var next *Piece
+	for _, p := range c.pieces {
+		p.mu.RLock()
+		if p.complete && p.Size >= p.Length && p.checked.Before(before) && (next == nil || p.checked.Before(next.checked)) {
+			next = p
+		}
+		p.mu.RUnlock()
+	}
+	if next == nil {
+		return -1, 0
+	}
+	return next.Id, next.Length
+}
+
+func (c *Cache) VerifyPiece(piece int) bool {
+	c.muPiece.Lock()
+	p, ok := c.pieces[piece]
+	c.muPiece.Unlock()
+	if !ok {
+		return true
+	}
+	if !p.verify() {
+		return false
+	}
+	c.muPiece.Lock()
+	c.verified++
+	c.muPiece.Unlock()
+	return true
+}
+
+// RemoveCorrupted releases piece without demoting it to lower tier
+func (c *Cache) RemoveCorrupted(piece int) {
+	fmt.Println("Corrupted piece in memory:", c.hash.HexString(), piece)
+	c.muPiece.Lock()
+	p, ok := c.pieces[piece]
+	if ok {
+		p.Release()
+		c.corrupted++
+	}
+	c.muPiece.Unlock()
+	if ok && c.s.onEvict != nil {
+		c.s.onEvict(c.hash, piece)
+	}
+}
+
+// verify rehashes piece data, piece is locked for writes while hashing
+func (p *Piece) verify() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.complete || p.chunks == nil || p.Size < p.Length {
+		return true
+	}
+	h := sha1.New()
+	chunkSize := p.cache.chunkSize
+	for off := int64(0); off < p.Length; off += chunkSize {
+		end := chunkSize
+		if off+end > p.Length {
+			end = p.Length - off
+		}
+		chunk := p.chunks[off/chunkSize]
+		if chunk == nil {
+			return false
+		}
+		h.Write(chunk[:end])
+	}
+	p.checked = time.Now()
+	return hex.EncodeToString(h.Sum(nil)) == p.Hash
+}
@@ -11,6 +11,18 @@ type CacheState struct {
 	PiecesLength int64
 	PiecesCount  int
 	Pieces       map[int]ItemState
+	Policy       string
+
+	BufferAllocated int64 // memory taken by pieces chunks
+	BufferFree      int64 // free chunks kept for reuse
+	Fragmentation   int   // percent of allocated memory without data
+	SlowBuffers     int   // chunks refused over capacity
+
+	VerifiedPieces  int // pieces rehashed by background verifier
+	CorruptedPieces int // pieces removed by verifier, because hash didn't match
+
+	DiskCapacity int64
+	DiskFilled   int64
 }
 
 type ItemState struct {
@@ -2,13 +2,10 @@ package utils
 
 import (
 	"encoding/base32"
-	"errors"
 	"math/rand"
-	"time"
 
 	"server/settings"
 
-	"github.com/anacrolix/torrent"
 	"golang.org/x/time/rate"
 )
 
@@ -28,6 +25,14 @@ var trackers = []string{
 }
 
 func GetDefTrackers() []string {
+	return GetRetrackers(settings.Get())
+}
+
+// GetRetrackers returns retrackers of settings, default list if settings have none
+func GetRetrackers(sets *settings.Settings) []string {
+	if len(sets.Retrackers) > 0 {
+		return sets.Retrackers
+	}
 	return trackers
 }
 
@@ -40,16 +45,6 @@ func PeerIDRandom(peer string) string {
 	return peer + base32.StdEncoding.EncodeToString(randomBytes)[:20-len(peer)]
 }
 
-func GotInfo(t *torrent.Torrent, timeout int) error {
-	gi := t.GotInfo()
-	select {
-	case <-gi:
-		return nil
-	case <-time.Tick(time.Second * time.Duration(timeout)):
-		return errors.New("timeout load torrent info")
-	}
-}
-
 func GetReadahead() int64 {
 	readahead := int64(float64(settings.Get().CacheSize) * 0.33)
 	if readahead < 66*1024*1024 {
@@ -62,7 +57,7 @@ func GetReadahead() int64 {
 }
 
 func Limit(i int) *rate.Limiter {
-	l := rate.NewLimiter(rate.Inf, 0)
+	l := rate.NewLimiter(rate.Inf, 16*1024)
 	if i > 0 {
 		b := i
 		if b < 16*1024 {
@@ -72,3 +67,15 @@ func Limit(i int) *rate.Limiter {
 	}
 	return l
 }
+
+// SetLimit changes rate of limiter in use, 0 - inf
+func SetLimit(l *rate.Limiter, i int) {
+	if l == nil {
+		return
+	}
+	if i > 0 {
+		l.SetLimit(rate.Limit(i))
+	} else {
+		l.SetLimit(rate.Inf)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"server/settings"
+
+	"github.com/labstack/echo"
+)
+
+func initBlocklistApi(e *echo.Echo) {
+	e.POST("/blocklist/import", blocklistImport)
+	e.POST("/blocklist/clear", blocklistClear)
+}
+
+// initBlocklist imports blocklist from saved source, if it wasn't converted yet
+func initBlocklist() {
+	src := settings.Get().BlocklistSource
+	if src == "" || bts.BTState().BlocklistRanges > 0 {
+		return
+	}
+	_, err := bts.ImportBlocklist(src)
+	if err != nil {
+		fmt.Println("Error import blocklist:", err)
+	}
+}
+
+type BlocklistRequest struct {
+	Source string // file path or url
+}
+
+func blocklistImport(c echo.Context) error {
+	buf, _ := ioutil.ReadAll(c.Request().Body)
+	decoder := json.NewDecoder(bytes.NewBuffer(buf))
+	jreq := new(BlocklistRequest)
+	err := decoder.Decode(jreq)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if jreq.Source == "" {
+		jreq.Source = settings.Get().BlocklistSource
+	}
+	if jreq.Source == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Source must be non-empty")
+	}
+
+	count, err := bts.ImportBlocklist(jreq.Source)
+	if err != nil {
+		fmt.Println("Error import blocklist:", err)
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	settings.Get().BlocklistSource = jreq.Source
+	settings.SaveSettings()
+	return c.JSON(http.StatusOK, count)
+}
+
+func blocklistClear(c echo.Context) error {
+	err := bts.ClearBlocklist()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	settings.Get().BlocklistSource = ""
+	settings.SaveSettings()
+	return c.NoContent(http.StatusOK)
+}
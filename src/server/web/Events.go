@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+func initEvents(e *echo.Echo) {
+	e.GET("/events", eventsStream)
+}
+
+// eventsStream sends torrents events as server-sent events, hash query filters one torrent
+func eventsStream(c echo.Context) error {
+	hash := strings.ToLower(c.QueryParam("hash"))
+
+	resp := c.Response()
+	resp.Header().Set("Content-Type", "text/event-stream")
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.Header().Set("Connection", "keep-alive")
+	resp.WriteHeader(http.StatusOK)
+	resp.Flush()
+
+	events := bts.Subscribe()
+	defer bts.Unsubscribe(events)
+
+	ping := time.NewTicker(time.Second * 15)
+	defer ping.Stop()
+	done := c.Request().Context().Done()
+
+	for {
+		select {
+		case ev := <-events:
+			if hash != "" && ev.Hash != hash {
+				continue
+			}
+			buf, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			_, err = fmt.Fprintf(resp, "event: %s\ndata: %s\n\n", ev.Type, buf)
+			if err != nil {
+				return nil
+			}
+			resp.Flush()
+		case <-ping.C:
+			_, err := fmt.Fprint(resp, ": ping\n\n")
+			if err != nil {
+				return nil
+			}
+			resp.Flush()
+		case <-done:
+			return nil
+		}
+	}
+}
@@ -33,8 +33,14 @@ func statePage(c echo.Context) error {
 	msg := ""
 
 	msg += fmt.Sprintf("Listen port: %d<br>\n", state.LocalPort)
+	if state.PortForward.Port > 0 {
+		msg += fmt.Sprintf("Port forwarding: UPnP: %s, external port %d, NAT-PMP: %s, external port %d, updated %s<br>\n", state.PortForward.UPnP, state.PortForward.UPnPPort, state.PortForward.NATPMP, state.PortForward.NATPMPPort, state.PortForward.Updated.Format("15:04:05"))
+	} else {
+		msg += "Port forwarding: disabled<br>\n"
+	}
 	msg += fmt.Sprintf("Peer ID: %+q<br>\n", state.PeerID)
 	msg += fmt.Sprintf("Banned IPs: %d<br>\n", state.BannedIPs)
+	msg += fmt.Sprintf("Blocklist ranges: %d<br>\n", state.BlocklistRanges)
 
 	for _, dht := range state.DHTs {
 		msg += fmt.Sprintf("%s DHT server at %s:<br>\n", dht.Addr().Network(), dht.Addr().String())
@@ -80,6 +86,12 @@ func statePage(c echo.Context) error {
 		msg += fmt.Sprintf("\t&emsp;ChunksReadWasted: %v<br>\n", st.ChunksReadWasted)
 		msg += fmt.Sprintf("\t&emsp;PiecesDirtiedGood: %v<br>\n", st.PiecesDirtiedGood)
 		msg += fmt.Sprintf("\t&emsp;PiecesDirtiedBad: %v<br>\n<br>\n", st.PiecesDirtiedBad)
+		if trackers := t.Trackers(); len(trackers) > 0 {
+			msg += fmt.Sprintf("\t&emsp;Trackers:<br>\n")
+			for _, tr := range trackers {
+				msg += fmt.Sprintf("\t&emsp;\t&emsp;[%v] %v %v Seeders:%v Leechers:%v %v<br>\n", tr.Tier, tr.Url, tr.Status, tr.Seeders, tr.Leechers, tr.LastError)
+			}
+		}
 		if len(st.FileStats) > 0 {
 			msg += fmt.Sprintf("\t&emsp;Files:<br>\n")
 			for _, f := range st.FileStats {
@@ -92,6 +104,9 @@ func statePage(c echo.Context) error {
 		if cState != nil {
 			msg += fmt.Sprintf("CacheType:<br>\n")
 			msg += fmt.Sprintf("Capacity: %v<br>\n", bytes.Format(cState.Capacity))
+			msg += fmt.Sprintf("Policy: %v<br>\n", cState.Policy)
+			msg += fmt.Sprintf("Verified pieces: %v, corrupted: %v<br>\n", cState.VerifiedPieces, cState.CorruptedPieces)
+			msg += fmt.Sprintf("Buffers: %v, free %v, fragmentation %v%%, slow %v<br>\n", bytes.Format(cState.BufferAllocated), bytes.Format(cState.BufferFree), cState.Fragmentation, cState.SlowBuffers)
 			msg += fmt.Sprintf("Filled: %v<br>\n", bytes.Format(cState.Filled))
 			msg += fmt.Sprintf("PiecesLength: %v<br>\n", bytes.Format(cState.PiecesLength))
 			msg += fmt.Sprintf("PiecesCount: %v<br>\n", cState.PiecesCount)
@@ -12,6 +12,7 @@ import (
 	"server/settings"
 	"server/torr"
 	"server/version"
+	"server/web/helpers"
 	"server/web/mods"
 	"server/web/templates"
 
@@ -40,6 +41,8 @@ func Start(port string) {
 		fmt.Println("Error start torrent client:", err)
 		return
 	}
+	go helpers.RestoreTorrents(bts, settings.Get().RestoreTorrents)
+	go initBlocklist()
 
 	mutex.Lock()
 	server = echo.New()
@@ -55,6 +58,8 @@ func Start(port string) {
 	initSettings(server)
 	initSearch(server)
 	initInfo(server)
+	initBlocklistApi(server)
+	initEvents(server)
 	initAbout(server)
 	mods.InitMods(server)
 
@@ -63,12 +68,18 @@ func Start(port string) {
 	server.POST("/shutdown", shutdownPage)
 	server.GET("/js/api.js", templates.Api_JS)
 
+	addr := "0.0.0.0:" + port
+	if settings.Get().EnableIPv6 {
+		//Dual stack, listen all ipv4 and ipv6 addresses
+		addr = ":" + port
+	}
+
 	go func() {
 		defer mutex.Unlock()
 
-		server.Listener, err = net.Listen("tcp", "0.0.0.0:"+port)
+		server.Listener, err = net.Listen("tcp", addr)
 		if err == nil {
-			err = server.Start("0.0.0.0:" + port)
+			err = server.Start(addr)
 		}
 		server = nil
 		if err != nil {
@@ -85,6 +96,7 @@ func Stop() {
 		server.Close()
 		server = nil
 		if bts != nil {
+			bts.SaveCache()
 			bts.Disconnect()
 			bts = nil
 		}
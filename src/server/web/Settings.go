@@ -27,11 +27,18 @@ func settingsRead(c echo.Context) error {
 }
 
 func settingsWrite(c echo.Context) error {
+	old := *settings.Get()
+	//Decoder reuses slice of settings, old list must not change with new one
+	old.Retrackers = append([]string(nil), old.Retrackers...)
 	err := getJsSettings(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 	settings.SaveSettings()
+	err = bts.ApplySettings(&old)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
 	return c.JSON(http.StatusOK, "Ok")
 }
 
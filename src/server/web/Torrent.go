@@ -18,6 +18,7 @@ import (
 	"server/web/helpers"
 
 	"github.com/anacrolix/missinggo/httptoo"
+	"github.com/anacrolix/torrent"
 	"github.com/anacrolix/torrent/metainfo"
 	"github.com/labstack/echo"
 )
@@ -31,6 +32,16 @@ func initTorrent(e *echo.Echo) {
 	e.POST("/torrent/stat", torrentStat)
 	e.POST("/torrent/cache", torrentCache)
 	e.POST("/torrent/drop", torrentDrop)
+	e.POST("/torrent/limits", torrentLimits)
+	e.POST("/torrent/pin", torrentPin)
+	e.POST("/torrent/trackers", torrentTrackers)
+	e.POST("/torrent/trackers/add", torrentTrackersAdd)
+	e.POST("/torrent/trackers/remove", torrentTrackersRemove)
+	e.POST("/torrent/peers", torrentPeers)
+	e.POST("/torrent/peers/add", torrentPeersAdd)
+	e.POST("/torrent/peers/ban", torrentPeersBan)
+	e.POST("/torrent/webseeds", torrentWebSeeds)
+	e.POST("/torrent/webseeds/add", torrentWebSeedsAdd)
 
 	e.GET("/torrent/restart", torrentRestart)
 
@@ -54,15 +65,16 @@ type TorrentJsonRequest struct {
 }
 
 type TorrentJsonResponse struct {
-	Name     string
-	Magnet   string
-	Hash     string
-	AddTime  int64
-	Length   int64
-	Status   torr.TorrentStatus
-	Playlist string
-	Info     string
-	Files    []TorFile `json:",omitempty"`
+	Name       string
+	Magnet     string
+	Hash       string
+	AddTime    int64
+	Length     int64
+	Status     torr.TorrentStatus
+	FailReason string `json:",omitempty"`
+	Playlist   string
+	Info       string
+	Files      []TorFile `json:",omitempty"`
 }
 
 type TorFile struct {
@@ -93,7 +105,7 @@ func torrentAdd(c echo.Context) error {
 		magnet.DisplayName = jreq.Title
 	}
 
-	err = helpers.Add(bts, *magnet, !jreq.DontSave)
+	err = helpers.Add(bts, magnet, !jreq.DontSave)
 	if err != nil {
 		fmt.Println("Error add torrent:", jreq.Hash, err)
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -116,7 +128,8 @@ func torrentUpload(c echo.Context) error {
 	defer form.RemoveAll()
 
 	_, dontSave := form.Value["DontSave"]
-	var magnets []metainfo.Magnet
+	var specs []*torrent.TorrentSpec
+	var seeds [][]settings.WebSeed
 
 	for _, file := range form.File {
 		torrFile, err := file[0].Open()
@@ -125,7 +138,12 @@ func torrentUpload(c echo.Context) error {
 		}
 		defer torrFile.Close()
 
-		mi, err := metainfo.Load(torrFile)
+		buf, err := ioutil.ReadAll(torrFile)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+
+		mi, err := metainfo.Load(bytes.NewReader(buf))
 		if err != nil {
 			fmt.Println("Error upload torrent", err)
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -136,18 +154,20 @@ func torrentUpload(c echo.Context) error {
 			fmt.Println("Error upload torrent", err)
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
-		magnet := mi.Magnet(info.Name, mi.HashInfoBytes())
-		magnets = append(magnets, magnet)
+		spec := torrent.TorrentSpecFromMetaInfo(mi)
+		spec.DisplayName = info.Name
+		specs = append(specs, spec)
+		seeds = append(seeds, helpers.GetWebSeeds(buf))
 	}
 
 	ret := make([]string, 0)
-	for _, magnet := range magnets {
-		er := helpers.Add(bts, magnet, !dontSave)
+	for i, spec := range specs {
+		er := helpers.AddSpec(bts, spec, seeds[i], !dontSave)
 		if er != nil {
 			err = er
-			fmt.Println("Error add torrent:", magnet.String(), er)
+			fmt.Println("Error add torrent:", spec.InfoHash.HexString(), er)
 		}
-		ret = append(ret, magnet.InfoHash.HexString())
+		ret = append(ret, spec.InfoHash.HexString())
 	}
 
 	return c.JSON(http.StatusOK, ret)
@@ -240,23 +260,27 @@ func torrentList(c echo.Context) error {
 
 	slist := bts.List()
 
-	find := func(tjs []TorrentJsonResponse, t *torr.Torrent) bool {
-		for _, j := range tjs {
+	find := func(tjs []TorrentJsonResponse, t *torr.Torrent) int {
+		for i, j := range tjs {
 			if t.Hash().HexString() == j.Hash {
-				return true
+				return i
 			}
 		}
-		return false
+		return -1
 	}
 
 	for _, st := range slist {
-		if !find(js, st) {
+		if i := find(js, st); i >= 0 {
+			js[i].Status = st.Status()
+			js[i].FailReason = st.FailReason()
+		} else {
 			tdb := toTorrentDB(st)
 			jsTor, err := getTorrentJS(tdb)
 			if err != nil {
 				fmt.Println("Error get torrent:", err)
 			} else {
 				jsTor.Status = st.Status()
+				jsTor.FailReason = st.FailReason()
 				js = append(js, *jsTor)
 			}
 		}
@@ -329,18 +353,18 @@ func preload(hashHex, fileLink string, size int64) *echo.HTTPError {
 			if err != nil || torrDb == nil {
 				return echo.NewHTTPError(http.StatusBadRequest, "Torrent not found: "+hashHex)
 			}
-			m, err := metainfo.ParseMagnetURI(torrDb.Magnet)
+			spec, err := helpers.GetSpec(torrDb)
 			if err != nil {
 				return echo.NewHTTPError(http.StatusBadRequest, "Error parser magnet in db: "+hashHex)
 			}
-			tor, err = bts.AddTorrent(m, nil)
+			tor, err = bts.AddTorrentSpec(spec, helpers.SaveMetainfo)
 			if err != nil {
 				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 			}
 		}
 
 		if !tor.WaitInfo() {
-			return echo.NewHTTPError(http.StatusBadRequest, "torrent closed befor get info")
+			return echo.NewHTTPError(http.StatusGatewayTimeout, tor.InfoError())
 		}
 
 		file := helpers.FindFileLink(fileLink, tor.Torrent)
@@ -482,10 +506,11 @@ func torrentPlay(c echo.Context) error {
 
 	tor := bts.GetTorrent(magnet.InfoHash)
 	if tor == nil {
-		tor, err = bts.AddTorrent(*magnet, nil)
+		tor, err = bts.AddTorrent(magnet.Magnet, nil)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 		}
+		tor.AddWebSeeds(magnet.WebSeeds)
 	}
 
 	if stat {
@@ -493,7 +518,7 @@ func torrentPlay(c echo.Context) error {
 	}
 
 	if !tor.WaitInfo() {
-		return echo.NewHTTPError(http.StatusBadRequest, "torrent closed befor get info")
+		return echo.NewHTTPError(http.StatusGatewayTimeout, tor.InfoError())
 	}
 
 	if strings.ToLower(qsave) == "true" {
@@ -506,7 +531,7 @@ func torrentPlay(c echo.Context) error {
 	}
 
 	if strings.ToLower(mm3u) == "true" {
-		mt := tor.Torrent.Metainfo()
+		mt := tor.Metainfo()
 		m3u := helpers.MakeM3UPlayList(tor.Stats(), mt.Magnet(tor.Name(), tor.Hash()).String(), c.Scheme()+"://"+c.Request().Host)
 		c.Response().Header().Set("Content-Type", "audio/x-mpegurl")
 		c.Response().Header().Set("Connection", "close")
@@ -558,19 +583,19 @@ func torrentView(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusBadRequest, "Torrent not found: "+hashHex)
 		}
 
-		m, err := metainfo.ParseMagnetURI(torrDb.Magnet)
+		spec, err := helpers.GetSpec(torrDb)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, "Error parser magnet in db: "+hashHex)
 		}
 
-		tor, err = bts.AddTorrent(m, nil)
+		tor, err = bts.AddTorrentSpec(spec, helpers.SaveMetainfo)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 	}
 
 	if !tor.WaitInfo() {
-		return echo.NewHTTPError(http.StatusBadRequest, "torrent closed befor get info")
+		return echo.NewHTTPError(http.StatusGatewayTimeout, tor.InfoError())
 	}
 
 	file := helpers.FindFileLink(fileLink, tor.Torrent)
@@ -588,9 +613,10 @@ func toTorrentDB(t *torr.Torrent) *settings.Torrent {
 	tor.Name = t.Name()
 	tor.Hash = t.Hash().HexString()
 	tor.Timestamp = settings.StartTime.Unix()
-	mi := t.Torrent.Metainfo()
+	mi := t.Metainfo()
 	tor.Magnet = mi.Magnet(t.Name(), t.Torrent.InfoHash()).String()
 	tor.Size = t.Length()
+	tor.Metainfo = helpers.GetMetainfoBytes(t)
 	files := t.Files()
 	sort.Slice(files, func(i, j int) bool {
 		return files[i].Path() < files[j].Path()
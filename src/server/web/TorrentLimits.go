@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"server/settings"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/labstack/echo"
+)
+
+type TorrentLimitsRequest struct {
+	Hash              string
+	DownloadRateLimit int // in kb, 0 - inf
+	UploadRateLimit   int // in kb, 0 - inf
+	ConnectionsLimit  int // 0 - from settings
+}
+
+func torrentLimits(c echo.Context) error {
+	buf, _ := ioutil.ReadAll(c.Request().Body)
+	decoder := json.NewDecoder(bytes.NewBuffer(buf))
+	jreq := new(TorrentLimitsRequest)
+	err := decoder.Decode(jreq)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if jreq.Hash == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Hash must be non-empty")
+	}
+
+	hash := metainfo.NewHashFromHex(jreq.Hash)
+	tor := bts.GetTorrent(hash)
+	if tor != nil {
+		tor.SetLimits(jreq.DownloadRateLimit, jreq.UploadRateLimit, jreq.ConnectionsLimit)
+	}
+
+	err = settings.SetTorrentLimits(hash.HexString(), jreq.DownloadRateLimit, jreq.UploadRateLimit, jreq.ConnectionsLimit)
+	if err != nil && tor == nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+type TorrentPinRequest struct {
+	Hash   string
+	Pinned bool
+}
+
+func torrentPin(c echo.Context) error {
+	buf, _ := ioutil.ReadAll(c.Request().Body)
+	decoder := json.NewDecoder(bytes.NewBuffer(buf))
+	jreq := new(TorrentPinRequest)
+	err := decoder.Decode(jreq)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if jreq.Hash == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Hash must be non-empty")
+	}
+
+	hash := metainfo.NewHashFromHex(jreq.Hash)
+	tor := bts.GetTorrent(hash)
+	if tor != nil {
+		tor.SetPinned(jreq.Pinned)
+	}
+
+	err = settings.SetPinned(hash.HexString(), jreq.Pinned)
+	if err != nil && tor == nil {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/labstack/echo"
+)
+
+type TorrentPeersRequest struct {
+	Hash string
+	Addr string // host:port for add, ip or host:port for ban
+}
+
+func getPeersRequest(c echo.Context) (*TorrentPeersRequest, error) {
+	buf, _ := ioutil.ReadAll(c.Request().Body)
+	decoder := json.NewDecoder(bytes.NewBuffer(buf))
+	jreq := new(TorrentPeersRequest)
+	err := decoder.Decode(jreq)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return jreq, nil
+}
+
+func torrentPeers(c echo.Context) error {
+	jreq, err := getPeersRequest(c)
+	if err != nil {
+		return err
+	}
+	if jreq.Hash == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Hash must be non-empty")
+	}
+	tor := bts.GetTorrent(metainfo.NewHashFromHex(jreq.Hash))
+	if tor == nil {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	return c.JSON(http.StatusOK, tor.Peers())
+}
+
+func torrentPeersAdd(c echo.Context) error {
+	jreq, err := getPeersRequest(c)
+	if err != nil {
+		return err
+	}
+	if jreq.Hash == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Hash must be non-empty")
+	}
+	tor := bts.GetTorrent(metainfo.NewHashFromHex(jreq.Hash))
+	if tor == nil {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	err = tor.AddPeer(jreq.Addr)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func torrentPeersBan(c echo.Context) error {
+	jreq, err := getPeersRequest(c)
+	if err != nil {
+		return err
+	}
+	host := jreq.Addr
+	if h, _, err := net.SplitHostPort(jreq.Addr); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Wrong peer ip")
+	}
+	bts.BanIP(ip)
+	return c.JSON(http.StatusOK, bts.BannedIPs())
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"server/torr"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/labstack/echo"
+)
+
+type TorrentTrackersRequest struct {
+	Hash     string
+	Trackers []string
+}
+
+func getTrackersRequest(c echo.Context) (*TorrentTrackersRequest, *torr.Torrent, error) {
+	buf, _ := ioutil.ReadAll(c.Request().Body)
+	decoder := json.NewDecoder(bytes.NewBuffer(buf))
+	jreq := new(TorrentTrackersRequest)
+	err := decoder.Decode(jreq)
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if jreq.Hash == "" {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Hash must be non-empty")
+	}
+
+	tor := bts.GetTorrent(metainfo.NewHashFromHex(jreq.Hash))
+	if tor == nil {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound)
+	}
+	return jreq, tor, nil
+}
+
+func torrentTrackers(c echo.Context) error {
+	_, tor, err := getTrackersRequest(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, tor.Trackers())
+}
+
+func torrentTrackersAdd(c echo.Context) error {
+	jreq, tor, err := getTrackersRequest(c)
+	if err != nil {
+		return err
+	}
+	if len(jreq.Trackers) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Trackers must be non-empty")
+	}
+	tor.AddTrackers([][]string{jreq.Trackers})
+	tor.SaveTrackers()
+	return c.JSON(http.StatusOK, tor.Trackers())
+}
+
+func torrentTrackersRemove(c echo.Context) error {
+	jreq, tor, err := getTrackersRequest(c)
+	if err != nil {
+		return err
+	}
+	for _, u := range jreq.Trackers {
+		tor.RemoveTracker(u)
+	}
+	tor.SaveTrackers()
+	return c.JSON(http.StatusOK, tor.Trackers())
+}
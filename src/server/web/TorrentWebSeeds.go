@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"server/settings"
+
+	"github.com/anacrolix/torrent/metainfo"
+	"github.com/labstack/echo"
+)
+
+type TorrentWebSeedRequest struct {
+	Hash     string
+	Url      string
+	HttpSeed bool // BEP 17 http seed, else BEP 19 web seed
+}
+
+func getWebSeedRequest(c echo.Context) (*TorrentWebSeedRequest, error) {
+	buf, _ := ioutil.ReadAll(c.Request().Body)
+	decoder := json.NewDecoder(bytes.NewBuffer(buf))
+	jreq := new(TorrentWebSeedRequest)
+	err := decoder.Decode(jreq)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if jreq.Hash == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Hash must be non-empty")
+	}
+	return jreq, nil
+}
+
+func torrentWebSeeds(c echo.Context) error {
+	jreq, err := getWebSeedRequest(c)
+	if err != nil {
+		return err
+	}
+	tor := bts.GetTorrent(metainfo.NewHashFromHex(jreq.Hash))
+	if tor == nil {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	return c.JSON(http.StatusOK, tor.WebSeedsStatus())
+}
+
+func torrentWebSeedsAdd(c echo.Context) error {
+	jreq, err := getWebSeedRequest(c)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(jreq.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return echo.NewHTTPError(http.StatusBadRequest, "Url must be http or https")
+	}
+
+	seed := settings.WebSeed{Url: jreq.Url, HttpSeed: jreq.HttpSeed}
+	hash := metainfo.NewHashFromHex(jreq.Hash)
+	tor := bts.GetTorrent(hash)
+	if tor != nil {
+		tor.AddWebSeeds([]settings.WebSeed{seed})
+		//Torrent may be not saved in db
+		settings.SetWebSeeds(hash.HexString(), tor.WebSeeds())
+	} else {
+		torrDb, er := settings.LoadTorrentDB(hash.HexString())
+		if er != nil || torrDb == nil {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		seeds := torrDb.WebSeeds
+		found := false
+		for _, s := range seeds {
+			found = found || s.Url == seed.Url
+		}
+		if !found {
+			seeds = append(seeds, seed)
+		}
+		err = settings.SetWebSeeds(hash.HexString(), seeds)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+	return c.NoContent(http.StatusOK)
+}
@@ -1,22 +1,32 @@
 package helpers
 
 import (
+	"bytes"
 	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
+	"server/settings"
+
+	"github.com/anacrolix/torrent/bencode"
 	"github.com/anacrolix/torrent/metainfo"
 )
 
-func GetMagnet(link string) (*metainfo.Magnet, error) {
+type Magnet struct {
+	metainfo.Magnet
+	WebSeeds []settings.WebSeed
+}
+
+func GetMagnet(link string) (*Magnet, error) {
 	url, err := url.Parse(link)
 	if err != nil {
 		return nil, err
 	}
 
-	var mag *metainfo.Magnet
+	var mag *Magnet
 	switch strings.ToLower(url.Scheme) {
 	case "magnet":
 		mag, err = getMag(url.String())
@@ -34,12 +44,21 @@ func GetMagnet(link string) (*metainfo.Magnet, error) {
 	return mag, nil
 }
 
-func getMag(link string) (*metainfo.Magnet, error) {
+func getMag(link string) (*Magnet, error) {
 	mag, err := metainfo.ParseMagnetURI(link)
-	return &mag, err
+	if err != nil {
+		return nil, err
+	}
+	ret := &Magnet{Magnet: mag}
+	if u, err := url.Parse(link); err == nil {
+		for _, ws := range u.Query()["ws"] {
+			ret.WebSeeds = append(ret.WebSeeds, settings.WebSeed{Url: ws})
+		}
+	}
+	return ret, nil
 }
 
-func getMagFromHttp(url string) (*metainfo.Magnet, error) {
+func getMagFromHttp(url string) (*Magnet, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
@@ -58,21 +77,23 @@ func getMagFromHttp(url string) (*metainfo.Magnet, error) {
 		return nil, errors.New(resp.Status)
 	}
 
-	minfo, err := metainfo.Load(resp.Body)
+	buf, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
-	info, err := minfo.UnmarshalInfo()
+	return getMagFromMetainfo(buf)
+}
+
+func getMagFromFile(path string) (*Magnet, error) {
+	buf, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	mag := minfo.Magnet(info.Name, minfo.HashInfoBytes())
-	return &mag, nil
+	return getMagFromMetainfo(buf)
 }
 
-func getMagFromFile(path string) (*metainfo.Magnet, error) {
-
-	minfo, err := metainfo.LoadFromFile(path)
+func getMagFromMetainfo(buf []byte) (*Magnet, error) {
+	minfo, err := metainfo.Load(bytes.NewReader(buf))
 	if err != nil {
 		return nil, err
 	}
@@ -82,5 +103,33 @@ func getMagFromFile(path string) (*metainfo.Magnet, error) {
 	}
 
 	mag := minfo.Magnet(info.Name, minfo.HashInfoBytes())
-	return &mag, nil
+	return &Magnet{Magnet: mag, WebSeeds: GetWebSeeds(buf)}, nil
+}
+
+// GetWebSeeds returns url-list (BEP 19) and httpseeds (BEP 17) of torrent file
+func GetWebSeeds(buf []byte) []settings.WebSeed {
+	var mi struct {
+		UrlList   interface{} `bencode:"url-list"`
+		HttpSeeds []string    `bencode:"httpseeds"`
+	}
+	if bencode.Unmarshal(buf, &mi) != nil {
+		return nil
+	}
+	var seeds []settings.WebSeed
+	switch list := mi.UrlList.(type) {
+	case string:
+		if list != "" {
+			seeds = append(seeds, settings.WebSeed{Url: list})
+		}
+	case []interface{}:
+		for _, u := range list {
+			if s, ok := u.(string); ok && s != "" {
+				seeds = append(seeds, settings.WebSeed{Url: s})
+			}
+		}
+	}
+	for _, u := range mi.HttpSeeds {
+		seeds = append(seeds, settings.WebSeed{Url: u, HttpSeed: true})
+	}
+	return seeds
 }
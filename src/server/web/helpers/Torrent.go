@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"bytes"
 	"fmt"
 	"sort"
 	"time"
@@ -13,15 +14,26 @@ import (
 	"github.com/anacrolix/torrent/metainfo"
 )
 
-func Add(bts *torr.BTServer, magnet metainfo.Magnet, save bool) error {
-	fmt.Println("Adding torrent", magnet.String())
-	_, err := bts.AddTorrent(magnet, func(torr *torr.Torrent) {
+func Add(bts *torr.BTServer, magnet *Magnet, save bool) error {
+	return AddSpec(bts, &torrent.TorrentSpec{
+		Trackers:    [][]string{magnet.Trackers},
+		DisplayName: magnet.DisplayName,
+		InfoHash:    magnet.InfoHash,
+	}, magnet.WebSeeds, save)
+}
+
+func AddSpec(bts *torr.BTServer, spec *torrent.TorrentSpec, seeds []settings.WebSeed, save bool) error {
+	magnet := GetSpecMagnet(spec)
+	fmt.Println("Adding torrent", magnet)
+	tor, err := bts.AddTorrentSpec(spec, func(torr *torr.Torrent) {
 		torDb := new(settings.Torrent)
 		torDb.Name = torr.Name()
 		torDb.Hash = torr.Hash().HexString()
 		torDb.Size = torr.Length()
-		torDb.Magnet = magnet.String()
+		torDb.Magnet = magnet
 		torDb.Timestamp = time.Now().Unix()
+		torDb.Metainfo = GetMetainfoBytes(torr)
+		torDb.WebSeeds = torr.WebSeeds()
 		files := torr.Files()
 		sort.Slice(files, func(i, j int) bool {
 			return files[i].Path() < files[j].Path()
@@ -45,9 +57,95 @@ func Add(bts *torr.BTServer, magnet metainfo.Magnet, save bool) error {
 	if err != nil {
 		return err
 	}
+	tor.AddWebSeeds(seeds)
 	return nil
 }
 
+// GetSpec returns torrent spec from db, with full info if metainfo was saved
+func GetSpec(torrDb *settings.Torrent) (*torrent.TorrentSpec, error) {
+	if len(torrDb.Metainfo) > 0 {
+		mi, err := metainfo.Load(bytes.NewReader(torrDb.Metainfo))
+		if err == nil {
+			return torrent.TorrentSpecFromMetaInfo(mi), nil
+		}
+		fmt.Println("Error load metainfo from db:", torrDb.Hash, err)
+	}
+	return torrent.TorrentSpecFromMagnetURI(torrDb.Magnet)
+}
+
+func GetSpecMagnet(spec *torrent.TorrentSpec) string {
+	mag := metainfo.Magnet{
+		InfoHash:    spec.InfoHash,
+		DisplayName: spec.DisplayName,
+	}
+	for _, tier := range spec.Trackers {
+		mag.Trackers = append(mag.Trackers, tier...)
+	}
+	return mag.String()
+}
+
+// SaveMetainfo stores metainfo for torrents saved before it was kept in db
+func SaveMetainfo(tor *torr.Torrent) {
+	buf := GetMetainfoBytes(tor)
+	if buf == nil {
+		return
+	}
+	err := settings.SetMetainfo(tor.Hash().HexString(), buf)
+	if err != nil {
+		fmt.Println("Error save metainfo:", tor.Hash().HexString(), err)
+	}
+}
+
+func GetMetainfoBytes(tor *torr.Torrent) []byte {
+	if tor.Torrent == nil || tor.Info() == nil {
+		return nil
+	}
+	mi := tor.Metainfo()
+	var buf bytes.Buffer
+	err := mi.Write(&buf)
+	if err != nil {
+		fmt.Println("Error save metainfo:", tor.Hash().HexString(), err)
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func RestoreTorrents(bts *torr.BTServer, count int) {
+	//Restored torrents are counted to open torrents limit and expire as others
+	if max := settings.Get().Expire.MaxTorrents; max > 0 && count > max {
+		count = max
+	}
+	if count <= 0 {
+		return
+	}
+	list, err := settings.LoadTorrentsDB()
+	if err != nil {
+		fmt.Println("Error restore torrents:", err)
+		return
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Accessed == list[j].Accessed {
+			return list[i].Timestamp > list[j].Timestamp
+		}
+		return list[i].Accessed > list[j].Accessed
+	})
+	if len(list) > count {
+		list = list[:count]
+	}
+	for _, t := range list {
+		spec, err := GetSpec(t)
+		if err != nil {
+			fmt.Println("Error restore torrent:", t.Hash, err)
+			continue
+		}
+		fmt.Println("Restore torrent:", t.Name)
+		_, err = bts.AddTorrentSpec(spec, SaveMetainfo)
+		if err != nil {
+			fmt.Println("Error restore torrent:", t.Hash, err)
+		}
+	}
+}
+
 func FindFileLink(fileLink string, torr *torrent.Torrent) *torrent.File {
 	for _, f := range torr.Files() {
 		if utils.CleanFName(f.Path()) == fileLink {
@@ -99,6 +99,10 @@ var cachePage = `
 					html += '<span>Filled: '+humanizeSize(st.Filled)+'</span><br>';
 					html += '<span>Pieces length: '+humanizeSize(st.PiecesLength)+'</span><br>';
 					html += '<span>Pieces count: '+st.PiecesCount+'</span><br>';
+					if (st.DiskCapacity > 0) {
+						html += '<span>Disk capacity: '+humanizeSize(st.DiskCapacity)+'</span><br>';
+						html += '<span>Disk filled: '+humanizeSize(st.DiskFilled)+'</span><br>';
+					}
 					$("#cacheInfo").html(html);
 					makePieces(st.PiecesCount);
 					for(var i = 0; i < st.PiecesCount; i++) {
@@ -51,6 +51,43 @@ var settingsPage = `
                     <div class="input-group-text">Размер буфера предзагрузки</div>
                 </div>
                 <input id="PreloadBufferSize" class="form-control" type="number" autocomplete="off">
+            </div>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Тип кэша</div>
+                </div>
+                <select id="CacheType" class="form-control">
+                    <option value="0">В памяти</option>
+                    <option value="1">На диске</option>
+                    <option value="2">В памяти и на диске</option>
+                </select>
+            </div>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Вытеснение из кэша в памяти</div>
+                </div>
+                <select id="CachePolicy" class="form-control">
+                    <option value="lru">Давно не читанные</option>
+                    <option value="playhead">Позади воспроизведения</option>
+                    <option value="arc">Сохранять читанные повторно</option>
+                </select>
+            </div>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Размер кэша на диске</div>
+                </div>
+                <input id="DiskCacheSize" class="form-control" type="number" autocomplete="off">
+            </div>
+            <div class="form-check">
+                <input id="CacheSnapshot" class="form-check-input" type="checkbox" autocomplete="off">
+                <label for="CacheSnapshot">Сохранять кэш в памяти при остановке сервера</label>
+            </div>
+            <div class="form-check">
+                <input id="VerifyPieces" class="form-check-input" type="checkbox" autocomplete="off">
+                <label for="VerifyPieces">Проверять целостность кэша в фоне</label>
             </div>
          	<small class="form-text text-muted">Размеры кэша и буфера указываются в мегабайтах</small>
 		<br>
@@ -73,6 +110,14 @@ var settingsPage = `
             <div class="form-check">
                 <input id="DisableUpload" class="form-check-input" type="checkbox" autocomplete="off">
                 <label for="DisableUpload">Отключить Отдачу</label>
+            </div>
+            <div class="form-check">
+                <input id="EnableIPv6" class="form-check-input" type="checkbox" autocomplete="off">
+                <label for="EnableIPv6">Включить IPv6</label>
+            </div>
+            <div class="form-check">
+                <input id="EnableLSD" class="form-check-input" type="checkbox" autocomplete="off">
+                <label for="EnableLSD">Поиск пиров в локальной сети (LSD)</label>
             </div>
 		<br>
             <div class="input-group">
@@ -93,6 +138,26 @@ var settingsPage = `
                 <input id="ConnectionsLimit" class="form-control" type="number" autocomplete="off">
             </div>
 		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Порт для входящих соединений</div>
+                </div>
+                <input id="PeersListenPort" class="form-control" type="number" autocomplete="off">
+            </div>
+            <small class="form-text text-muted">0 - случайный порт</small>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Блоклист</div>
+                </div>
+                <input id="BlocklistSource" class="form-control" type="text" autocomplete="off" placeholder="Путь к файлу или ссылка">
+                <div class="input-group-append">
+                    <button class="btn btn-outline-secondary" type="button" onclick="loadBlocklist()">Загрузить</button>
+                    <button class="btn btn-outline-secondary" type="button" onclick="removeBlocklist()">Очистить</button>
+                </div>
+            </div>
+            <small class="form-text text-muted">Форматы P2P, DAT, eMule, можно в gzip</small>
+		<br>
             <div class="input-group">
                 <div class="input-group-prepend">
                     <div class="input-group-text">Ограничение загрузки</div>
@@ -108,6 +173,42 @@ var settingsPage = `
             </div>
             <small class="form-text text-muted">Ограничение устанавливается в Килобайтах, 0 - не ограничивать</small>
 	 	<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Представляться как</div>
+                </div>
+                <select id="PeerProfile" class="form-control">
+                    <option value="utorrent">uTorrent 3.4.9</option>
+                    <option value="qbittorrent">qBittorrent 4.1.1</option>
+                    <option value="transmission">Transmission 2.94</option>
+                    <option value="deluge">Deluge 1.3.15</option>
+                    <option value="torrserver">TorrServer</option>
+                    <option value="custom">Свой</option>
+                </select>
+            </div>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Peer ID</div>
+                </div>
+                <input id="PeerIDPrefix" class="form-control" type="text" autocomplete="off">
+            </div>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">BEP20</div>
+                </div>
+                <input id="PeerBep20" class="form-control" type="text" autocomplete="off">
+            </div>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">User Agent</div>
+                </div>
+                <input id="PeerUserAgent" class="form-control" type="text" autocomplete="off">
+            </div>
+            <small class="form-text text-muted">Peer ID, BEP20 и User Agent используются только для своего профиля</small>
+	 	<br>
             <div class="input-group">
                 <div class="input-group-prepend">
                     <div class="input-group-text">Ретрекеры</div>
@@ -118,6 +219,72 @@ var settingsPage = `
                     <option value="2">Удалить</option>
                 </select>
             </div>
+		<br>
+            <div class="form-group">
+                <label for="Retrackers">Список ретрекеров</label>
+                <textarea id="Retrackers" class="form-control" rows="5" autocomplete="off" placeholder="По одному в строке, пусто - список по умолчанию"></textarea>
+            </div>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Восстанавливать торренты при запуске</div>
+                </div>
+                <input id="RestoreTorrents" class="form-control" type="number" autocomplete="off">
+            </div>
+            <small class="form-text text-muted">Количество последних просмотренных торрентов, 0 - не восстанавливать</small>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Ожидание информации о торренте</div>
+                </div>
+                <input id="MetadataTimeout" class="form-control" type="number" autocomplete="off">
+            </div>
+            <small class="form-text text-muted">В секундах, 0 - ждать бесконечно</small>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Буфер воспроизведения</div>
+                </div>
+                <input id="StreamBufferTime" class="form-control" type="number" autocomplete="off">
+            </div>
+            <small class="form-text text-muted">Время в секундах, на которое загружается вперёд каждый воспроизводимый поток, прежде чем скорость получат остальные торренты, 0 - не распределять</small>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Закрывать торрент после просмотра через</div>
+                </div>
+                <input id="IdleTimeout" class="form-control" type="number" autocomplete="off">
+            </div>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Закрывать торрент после получения информации через</div>
+                </div>
+                <input id="InfoTimeout" class="form-control" type="number" autocomplete="off">
+            </div>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Закрывать торрент после предзагрузки через</div>
+                </div>
+                <input id="PreloadTimeout" class="form-control" type="number" autocomplete="off">
+            </div>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Закрывать закреплённый торрент через</div>
+                </div>
+                <input id="PinnedTimeout" class="form-control" type="number" autocomplete="off">
+            </div>
+            <small class="form-text text-muted">Время указывается в секундах, 0 - не закрывать закреплённые торренты</small>
+		<br>
+            <div class="input-group">
+                <div class="input-group-prepend">
+                    <div class="input-group-text">Количество открытых торрентов</div>
+                </div>
+                <input id="MaxTorrents" class="form-control" type="number" autocomplete="off">
+            </div>
+            <small class="form-text text-muted">Давно не используемые торренты закрываются, 0 - не ограничивать</small>
         </form>
         <br>
         <div class="btn-group d-flex" role="group">
@@ -135,24 +302,46 @@ var settingsPage = `
             var data = {};
             data.CacheSize = Number($('#CacheSize').val())*(1024*1024);
 			data.PreloadBufferSize = Number($('#PreloadBufferSize').val())*(1024*1024);
+			data.CacheType = Number($('#CacheType').val());
+			data.CachePolicy = $('#CachePolicy').val();
+			data.CacheSnapshot = $('#CacheSnapshot').prop('checked');
+			data.VerifyPieces = $('#VerifyPieces').prop('checked');
+			data.DiskCacheSize = Number($('#DiskCacheSize').val())*(1024*1024);
 			
 			data.DisableTCP = $('#DisableTCP').prop('checked');
 			data.DisableUTP = $('#DisableUTP').prop('checked');
 			data.DisableUPNP = $('#DisableUPNP').prop('checked');
 			data.DisableDHT = $('#DisableDHT').prop('checked');
 			data.DisableUpload = $('#DisableUpload').prop('checked');
+			data.EnableIPv6 = $('#EnableIPv6').prop('checked');
+			data.EnableLSD = $('#EnableLSD').prop('checked');
 			data.Encryption = Number($('#Encryption').val());
  
 			data.ConnectionsLimit = Number($('#ConnectionsLimit').val());
+			data.PeersListenPort = Number($('#PeersListenPort').val());
  
 			data.DownloadRateLimit = Number($('#DownloadRateLimit').val());
 			data.UploadRateLimit = Number($('#UploadRateLimit').val());
 			
 			data.RetrackersMode = Number($('#RetrackersMode').val());
+			data.Retrackers = $('#Retrackers').val().split("\n").map(function(s){ return s.trim(); }).filter(function(s){ return s.length > 0; });
+			data.PeerProfile = $('#PeerProfile').val();
+			data.PeerIDPrefix = $('#PeerIDPrefix').val();
+			data.PeerBep20 = $('#PeerBep20').val();
+			data.PeerUserAgent = $('#PeerUserAgent').val();
+			data.RestoreTorrents = Number($('#RestoreTorrents').val());
+			data.MetadataTimeout = Number($('#MetadataTimeout').val());
+			data.StreamBufferTime = Number($('#StreamBufferTime').val());
+			data.Expire = {
+				IdleTimeout: Number($('#IdleTimeout').val()),
+				InfoTimeout: Number($('#InfoTimeout').val()),
+				PreloadTimeout: Number($('#PreloadTimeout').val()),
+				PinnedTimeout: Number($('#PinnedTimeout').val()),
+				MaxTorrents: Number($('#MaxTorrents').val())
+			};
          
             $.post("/settings/write", JSON.stringify(data))
                 .done(function(data) {
-         			restartService();
                     alert(data);
                 })
                 .fail(function(data) {
@@ -160,25 +349,64 @@ var settingsPage = `
                 });
         }
 
+        function loadBlocklist() {
+            importBlocklist($('#BlocklistSource').val(), function(data) {
+                alert("Загружено диапазонов: " + data);
+            }, function(data) {
+                alert(data.responseJSON.message);
+            });
+        }
+
+        function removeBlocklist() {
+            clearBlocklist(function() {
+                $('#BlocklistSource').val("");
+                alert("Блоклист удален");
+            }, function(data) {
+                alert(data.responseJSON.message);
+            });
+        }
+
         function refreshSettings() {
             $.post("/settings/read")
                 .done(function(data) {
          			$('#CacheSize').val(data.CacheSize/(1024*1024));
 					$('#PreloadBufferSize').val(data.PreloadBufferSize/(1024*1024));
+					$('#CacheType').val(data.CacheType);
+					$('#CachePolicy').val(data.CachePolicy || "lru");
+					$('#CacheSnapshot').prop('checked', data.CacheSnapshot);
+					$('#VerifyPieces').prop('checked', data.VerifyPieces);
+					$('#DiskCacheSize').val(data.DiskCacheSize/(1024*1024));
 					
          			$('#DisableTCP').prop('checked', data.DisableTCP);
 					$('#DisableUTP').prop('checked', data.DisableUTP);
 					$('#DisableUPNP').prop('checked', data.DisableUPNP);
 					$('#DisableDHT').prop('checked', data.DisableDHT);
 					$('#DisableUpload').prop('checked', data.DisableUpload);
+					$('#EnableIPv6').prop('checked', data.EnableIPv6);
+					$('#EnableLSD').prop('checked', data.EnableLSD);
 					$('#Encryption').val(data.Encryption);
          
          			$('#ConnectionsLimit').val(data.ConnectionsLimit);
+					$('#PeersListenPort').val(data.PeersListenPort);
+					$('#BlocklistSource').val(data.BlocklistSource);
          
 					$('#DownloadRateLimit').val(data.DownloadRateLimit);
 					$('#UploadRateLimit').val(data.UploadRateLimit);
 					
          			$('#RetrackersMode').val(data.RetrackersMode);
+					$('#Retrackers').val((data.Retrackers || []).join("\n"));
+					$('#PeerProfile').val(data.PeerProfile);
+					$('#PeerIDPrefix').val(data.PeerIDPrefix);
+					$('#PeerBep20').val(data.PeerBep20);
+					$('#PeerUserAgent').val(data.PeerUserAgent);
+					$('#RestoreTorrents').val(data.RestoreTorrents);
+					$('#MetadataTimeout').val(data.MetadataTimeout);
+					$('#StreamBufferTime').val(data.StreamBufferTime);
+					$('#IdleTimeout').val(data.Expire.IdleTimeout);
+					$('#InfoTimeout').val(data.Expire.InfoTimeout);
+					$('#PreloadTimeout').val(data.Expire.PreloadTimeout);
+					$('#PinnedTimeout').val(data.Expire.PinnedTimeout);
+					$('#MaxTorrents').val(data.Expire.MaxTorrents);
                 });
         };
 
@@ -75,6 +75,174 @@ function cacheTorrent(hash, done, fail){
 	});
 }
 
+function limitsTorrent(hash, download, upload, connections, done, fail){
+	var reqJson = JSON.stringify({ Hash: hash, DownloadRateLimit: download, UploadRateLimit: upload, ConnectionsLimit: connections});
+	$.post('/torrent/limits',reqJson)
+	.done(function( data ) {
+		if (done)
+			done(data);
+	})
+	.fail(function( data ) {
+		if (fail)
+			fail(data);
+	});
+}
+
+function pinTorrent(hash, pinned, done, fail){
+	var reqJson = JSON.stringify({ Hash: hash, Pinned: pinned});
+	$.post('/torrent/pin',reqJson)
+	.done(function( data ) {
+		if (done)
+			done(data);
+	})
+	.fail(function( data ) {
+		if (fail)
+			fail(data);
+	});
+}
+
+function trackersTorrent(hash, done, fail){
+	var reqJson = JSON.stringify({ Hash: hash});
+	$.post('/torrent/trackers',reqJson)
+	.done(function( data ) {
+		if (done)
+			done(data);
+	})
+	.fail(function( data ) {
+		if (fail)
+			fail(data);
+	});
+}
+
+function addTrackersTorrent(hash, trackers, done, fail){
+	var reqJson = JSON.stringify({ Hash: hash, Trackers: trackers});
+	$.post('/torrent/trackers/add',reqJson)
+	.done(function( data ) {
+		if (done)
+			done(data);
+	})
+	.fail(function( data ) {
+		if (fail)
+			fail(data);
+	});
+}
+
+function removeTrackersTorrent(hash, trackers, done, fail){
+	var reqJson = JSON.stringify({ Hash: hash, Trackers: trackers});
+	$.post('/torrent/trackers/remove',reqJson)
+	.done(function( data ) {
+		if (done)
+			done(data);
+	})
+	.fail(function( data ) {
+		if (fail)
+			fail(data);
+	});
+}
+
+function peersTorrent(hash, done, fail){
+	var reqJson = JSON.stringify({ Hash: hash});
+	$.post('/torrent/peers',reqJson)
+	.done(function( data ) {
+		if (done)
+			done(data);
+	})
+	.fail(function( data ) {
+		if (fail)
+			fail(data);
+	});
+}
+
+function addPeerTorrent(hash, addr, done, fail){
+	var reqJson = JSON.stringify({ Hash: hash, Addr: addr});
+	$.post('/torrent/peers/add',reqJson)
+	.done(function( data ) {
+		if (done)
+			done(data);
+	})
+	.fail(function( data ) {
+		if (fail)
+			fail(data);
+	});
+}
+
+function banPeer(addr, done, fail){
+	var reqJson = JSON.stringify({ Addr: addr});
+	$.post('/torrent/peers/ban',reqJson)
+	.done(function( data ) {
+		if (done)
+			done(data);
+	})
+	.fail(function( data ) {
+		if (fail)
+			fail(data);
+	});
+}
+
+function webSeedsTorrent(hash, done, fail){
+	var reqJson = JSON.stringify({ Hash: hash});
+	$.post('/torrent/webseeds',reqJson)
+	.done(function( data ) {
+		if (done)
+			done(data);
+	})
+	.fail(function( data ) {
+		if (fail)
+			fail(data);
+	});
+}
+
+function addWebSeedTorrent(hash, url, httpSeed, done, fail){
+	var reqJson = JSON.stringify({ Hash: hash, Url: url, HttpSeed: httpSeed});
+	$.post('/torrent/webseeds/add',reqJson)
+	.done(function( data ) {
+		if (done)
+			done(data);
+	})
+	.fail(function( data ) {
+		if (fail)
+			fail(data);
+	});
+}
+
+function watchEvents(hash, onEvent){
+	var url = '/events';
+	if (hash)
+		url += '?hash=' + hash;
+	var source = new EventSource(url);
+	['added', 'info', 'status', 'preload', 'reader', 'evict', 'closed'].forEach(function(type) {
+		source.addEventListener(type, function(e) {
+			onEvent(JSON.parse(e.data));
+		});
+	});
+	return source;
+}
+
+function importBlocklist(source, done, fail){
+	var reqJson = JSON.stringify({ Source: source});
+	$.post('/blocklist/import',reqJson)
+	.done(function( data ) {
+		if (done)
+			done(data);
+	})
+	.fail(function( data ) {
+		if (fail)
+			fail(data);
+	});
+}
+
+function clearBlocklist(done, fail){
+	$.post('/blocklist/clear')
+	.done(function( data ) {
+		if (done)
+			done(data);
+	})
+	.fail(function( data ) {
+		if (fail)
+			fail(data);
+	});
+}
+
 function listTorrent(done, fail){
 	$.post('/torrent/list')
 	.done(function( data ) {